@@ -2,108 +2,791 @@ package main
 
 import (
 	"bytes"
+	"container/list"
+	"html/template"
+	"path"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/esote/gitweb/internal/git"
+	"github.com/esote/gitweb/internal/highlight"
+	"github.com/esote/gitweb/internal/markdown"
 )
 
-type timePair struct {
-	b []byte
-	t time.Time
+// cacheKey identifies a cached page. Pages of a ref are keyed by the commit
+// the ref resolved to, and the refs page by the state of all refs, so entries
+// become unreachable as soon as a ref moves rather than after a fixed time.
+// Repositories replaced by a reload get new entries.
+type cacheKey struct {
+	repo   *repository
+	kind   int
+	ref    string
+	hash   string
+	path   string
+	ofs    int
+	view   diffView
+	source bool
 }
 
 const (
-	cacheCount = 2
-
 	keyLog int = iota
-	keyLs
+	keyTree
+	keyRefs
+	keyCommit
+	keyFile
+	keyBlame
+	keyCompare
+	keySummary
 )
 
-func logCached(repo *repository) ([]byte, error) {
-	if repo.cache != nil {
-		repo.mu.Lock()
-		defer repo.mu.Unlock()
+// lru is a least recently used cache of rendered pages, and the rows of file
+// and blame pages, shared by all repositories, bounded by their total size.
+type lru struct {
+	mu    sync.Mutex
+	max   int
+	size  int
+	order *list.List
+	items map[cacheKey]*list.Element
+}
+
+type lruEntry struct {
+	key  cacheKey
+	v    interface{}
+	size int
+	t    time.Time
+}
+
+var pages *lru
+
+func newLRU(max int) *lru {
+	return &lru{
+		max:   max,
+		order: list.New(),
+		items: make(map[cacheKey]*list.Element),
+	}
+}
+
+func (c *lru) get(key cacheKey) (*lruEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry), true
+}
+
+func (c *lru) add(key cacheKey, v interface{}, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+
+	// pages larger than the whole cache are not worth evicting everything
+	if size > c.max {
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{
+		key:  key,
+		v:    v,
+		size: size,
+		t:    time.Now().UTC(),
+	})
+	c.size += size
+	c.evict()
+}
+
+// Change the maximum size of the cache, evicting pages if it shrank.
+func (c *lru) resize(max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.max = max
+	c.evict()
+}
+
+func (c *lru) evict() {
+	for c.size > c.max {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *lru) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*lruEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+}
+
+// Retrieve the page for key from the cache, rendering and caching it if
+// missing or older than the repository cache duration.
+func cached(repo *repository, key cacheKey, render func() ([]byte, error)) ([]byte, error) {
+	v, err := cachedValue(repo, key, func() (interface{}, int, error) {
+		b, err := render()
+		return b, len(b), err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]byte), nil
+}
+
+// Retrieve the value for key from the cache like cached, render also returning
+// the size of the value.
+func cachedValue(repo *repository, key cacheKey,
+	render func() (interface{}, int, error)) (interface{}, error) {
+	if !repo.cache {
+		v, _, err := render()
+		return v, err
+	}
+
+	key.repo = repo
+
+	e, hit := pages.get(key)
+	if hit && (repo.d == 0 || time.Now().UTC().Sub(e.t) < repo.d) {
+		return e.v, nil
+	}
+
+	v, size, err := render()
+	if err != nil {
+		return nil, err
+	}
+
+	pages.add(key, v, size)
+	return v, nil
+}
+
+func logCached(repo *repository, ref, path string, ofs int) ([]byte, error) {
+	hash, err := repo.Git.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	key := cacheKey{kind: keyLog, ref: ref, hash: hash, path: path, ofs: ofs}
+
+	return cached(repo, key, func() ([]byte, error) {
+		// one extra to know if there is a next page
+		ret, err := repo.Git.Log(hash, path, ofs, repo.pageSize+1)
+		if err != nil {
+			return nil, err
+		}
+
+		title := repo.Name + " - Log " + ref
+		if path != "" {
+			title += ":" + path
+		}
+
+		var page = struct {
+			page
+			Path   string
+			Crumbs []crumb
+			Items  []*git.LogItem
+			Ofs    int
+			Prev   int
+			Next   int
+		}{
+			page: page{
+				Repo:      repo,
+				Title:     title,
+				Integrity: integrity,
+				Ref:       ref,
+			},
+			Path:   path,
+			Crumbs: crumbs(path),
+			Items:  ret,
+			Ofs:    ofs,
+			Prev:   ofs - repo.pageSize,
+		}
+
+		if page.Prev < 0 {
+			page.Prev = 0
+		}
+
+		if len(ret) > repo.pageSize {
+			page.Items = ret[:repo.pageSize]
+			page.Next = ofs + repo.pageSize
+		}
+
+		var b bytes.Buffer
+		if err = templates["log"].Execute(&b, page); err != nil {
+			return nil, err
+		}
+
+		return b.Bytes(), nil
+	})
+}
+
+func treeCached(repo *repository, ref, path string) ([]byte, error) {
+	hash, err := repo.Git.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	key := cacheKey{kind: keyTree, ref: ref, hash: hash, path: path}
+
+	return cached(repo, key, func() ([]byte, error) {
+		ret, err := repo.Git.Ls(hash, path)
+		if err != nil {
+			return nil, err
+		}
+
+		title := repo.Name + " - Files " + ref
+		if path != "" {
+			title += ":" + path
+		}
+
+		var page = struct {
+			page
+			Path   string
+			Dir    string
+			Parent string
+			Crumbs []crumb
+			Items  []*git.LsItem
+		}{
+			page: page{
+				Repo:      repo,
+				Title:     title,
+				Integrity: integrity,
+				Ref:       ref,
+			},
+			Path:   path,
+			Crumbs: crumbs(path),
+			Items:  ret,
+		}
+
+		if path != "" {
+			page.Dir = path + "/"
+			page.Parent = parentDir(path)
+		}
+
+		var b bytes.Buffer
+		if err = templates["tree"].Execute(&b, page); err != nil {
+			return nil, err
+		}
+
+		return b.Bytes(), nil
+	})
+}
+
+func refsCached(repo *repository) ([]byte, error) {
+	state, err := repo.Git.RefsState()
+	if err != nil {
+		return nil, err
+	}
+
+	key := cacheKey{kind: keyRefs, hash: state}
+
+	return cached(repo, key, func() ([]byte, error) {
+		ret, err := repo.Git.Refs()
+		if err != nil {
+			return nil, err
+		}
+
+		var page = struct {
+			page
+			Branches []*git.RefItem
+			Tags     []*git.RefItem
+		}{
+			page: page{
+				Repo:      repo,
+				Title:     repo.Name + " - Refs",
+				Integrity: integrity,
+				Ref:       repo.Git.Ref(),
+			},
+		}
+
+		for _, item := range ret {
+			if item.IsTag() {
+				page.Tags = append(page.Tags, item)
+			} else {
+				page.Branches = append(page.Branches, item)
+			}
+		}
+
+		var b bytes.Buffer
+		if err = templates["refs"].Execute(&b, page); err != nil {
+			return nil, err
+		}
+
+		return b.Bytes(), nil
+	})
+}
+
+// summaryCount is how many commits, branches and tags the summary shows.
+const summaryCount = 10
+
+func summaryCached(repo *repository) ([]byte, error) {
+	ref := repo.Git.Ref()
+
+	state, err := repo.Git.RefsState()
+	if err != nil {
+		return nil, err
+	}
+
+	// the state of the refs covers the commit ref resolves to
+	key := cacheKey{kind: keySummary, ref: ref, hash: state}
+
+	return cached(repo, key, func() ([]byte, error) {
+		hash, err := repo.Git.Resolve(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		commits, err := repo.Git.Log(hash, "", 0, summaryCount)
+		if err != nil {
+			return nil, err
+		}
+
+		refs, err := repo.Git.Refs()
+		if err != nil {
+			return nil, err
+		}
+
+		items, err := repo.Git.Ls(hash, "")
+		if err != nil {
+			return nil, err
+		}
+
+		var page = struct {
+			page
+			Commits  []*git.LogItem
+			Branches []*git.RefItem
+			Tags     []*git.RefItem
 
-		v, hit := repo.cache.Get(keyLog)
-		if hit && time.Now().UTC().Sub(v.(timePair).t) < repo.d {
-			return v.(timePair).b, nil
+			MoreBranches bool
+			MoreTags     bool
+
+			Readme   string
+			Rendered template.HTML
+			Plain    string
+		}{
+			page: page{
+				Repo:      repo,
+				Title:     repo.Name + " - Summary",
+				Integrity: integrity,
+				Ref:       ref,
+			},
+			Commits: commits,
+		}
+
+		// newest refs first
+		sort.SliceStable(refs, func(i, j int) bool {
+			return refs[i].Time.After(refs[j].Time)
+		})
+
+		for _, item := range refs {
+			if item.IsTag() {
+				page.Tags = append(page.Tags, item)
+			} else {
+				page.Branches = append(page.Branches, item)
+			}
+		}
+
+		if len(page.Branches) > summaryCount {
+			page.Branches = page.Branches[:summaryCount]
+			page.MoreBranches = true
 		}
-		repo.cache.Delete(keyLog)
+
+		if len(page.Tags) > summaryCount {
+			page.Tags = page.Tags[:summaryCount]
+			page.MoreTags = true
+		}
+
+		if page.Readme = findReadme(items); page.Readme != "" {
+			out, err := repo.Git.Show(hash, page.Readme)
+			if err != nil {
+				return nil, err
+			}
+
+			switch {
+			case out.Binary:
+				page.Readme = ""
+			case isMarkdown(page.Readme):
+				page.Rendered = markdown.Render(out.File,
+					rawLinks(repo, ref, page.Readme))
+			default:
+				page.Plain = string(out.File)
+			}
+		}
+
+		var b bytes.Buffer
+		if err = templates["summary"].Execute(&b, page); err != nil {
+			return nil, err
+		}
+
+		return b.Bytes(), nil
+	})
+}
+
+func commitCached(repo *repository, hash string, view diffView) ([]byte,
+	error) {
+	key := cacheKey{kind: keyCommit, hash: hash, view: view}
+
+	return cached(repo, key, func() ([]byte, error) {
+		out, err := repo.Git.Commit(hash, view.Parent, view.Opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var page = struct {
+			page
+			Commit *git.Commit
+			Hash   string
+			View   diffView
+		}{
+			page: page{
+				Repo:      repo,
+				Title:     repo.Name + " - Commit " + hash,
+				Integrity: integrity,
+				Ref:       repo.Git.Ref(),
+			},
+			Commit: out,
+			Hash:   hash,
+			View:   view,
+		}
+
+		var b bytes.Buffer
+		if err = templates["commit"].Execute(&b, page); err != nil {
+			return nil, err
+		}
+
+		return b.Bytes(), nil
+	})
+}
+
+func compareCached(repo *repository, base, head string,
+	view diffView) ([]byte, error) {
+	baseHash, err := repo.Git.Resolve(base)
+	if err != nil {
+		return nil, err
 	}
 
-	ret, err := repo.Git.Log()
+	headHash, err := repo.Git.Resolve(head)
 	if err != nil {
 		return nil, err
 	}
 
+	spec := base + "..." + head
+	key := cacheKey{kind: keyCompare, ref: spec, hash: baseHash + headHash,
+		view: view}
+
+	return cached(repo, key, func() ([]byte, error) {
+		out, err := repo.Git.Compare(baseHash, headHash, repo.pageSize,
+			view.Opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var page = struct {
+			page
+			Base    string
+			Head    string
+			Spec    string
+			Compare *git.Comparison
+			View    diffView
+		}{
+			page: page{
+				Repo:      repo,
+				Title:     repo.Name + " - Compare " + spec,
+				Integrity: integrity,
+				Ref:       repo.Git.Ref(),
+			},
+			Base:    base,
+			Head:    head,
+			Spec:    spec,
+			Compare: out,
+			View:    view,
+		}
+
+		var b bytes.Buffer
+		if err = templates["compare"].Execute(&b, page); err != nil {
+			return nil, err
+		}
+
+		return b.Bytes(), nil
+	})
+}
+
+// rows are the numbered lines of a file or blame page rendered once as table
+// rows, so a range of them can be marked without rendering them again.
+type rows struct {
+	b []byte
+
+	// where the row of each line starts in b
+	starts []int
+}
+
+// Render the row of each of n lines with the named template of a page.
+func renderRows(page, name string, n int, line func(int) interface{}) (*rows,
+	error) {
+	r := &rows{starts: make([]int, n)}
+
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		r.starts[i] = b.Len()
+		if err := templates[page].ExecuteTemplate(&b, name,
+			line(i)); err != nil {
+			return nil, err
+		}
+	}
+
+	r.b = b.Bytes()
+	return r, nil
+}
+
+// Split the rows into the sections before, in and after the range.
+func (r *rows) sections(lines lineRange) []section {
+	ret := lines.sections(len(r.starts))
+	for i, s := range ret {
+		ret[i].Rows = template.HTML(r.b[r.start(s.From):r.start(s.To)])
+	}
+	return ret
+}
+
+func (r *rows) start(i int) int {
+	if i == len(r.starts) {
+		return len(r.b)
+	}
+	return r.starts[i]
+}
+
+// The size of the rows, approximately.
+func (r *rows) size() int {
+	return len(r.b) + 8*len(r.starts)
+}
+
+// fileLine is a numbered line of a file, split into highlighted tokens.
+type fileLine struct {
+	Number int
+	Tokens []highlight.Token
+}
+
+// fileBody is the cached part of a file page, which is the same for every
+// range of its lines.
+type fileBody struct {
+	binary      bool
+	markdown    bool
+	rendered    template.HTML
+	highlighted bool
+	rows        *rows
+}
+
+func fileCached(repo *repository, ref, file string, lines lineRange,
+	source bool) ([]byte, error) {
+	hash, err := repo.Git.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// Markdown is rendered unless its source or a range of its lines was
+	// asked for, or it is too large to render
+	source = source || lines.Start != 0
+
+	key := cacheKey{
+		kind:   keyFile,
+		ref:    ref,
+		hash:   hash,
+		path:   file,
+		source: source,
+	}
+
+	v, err := cachedValue(repo, key, func() (interface{}, int, error) {
+		out, err := repo.Git.Show(hash, file)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		body := &fileBody{
+			binary: out.Binary,
+			markdown: isMarkdown(file) && !out.Binary &&
+				len(out.File) <= markdown.MaxSize,
+		}
+
+		if body.markdown && !source {
+			body.rendered = markdown.Render(out.File,
+				rawLinks(repo, ref, file))
+		}
+
+		var tokens []highlight.Token
+
+		if body.rendered == "" {
+			// files in unknown languages are a single plain token
+			tokens = highlight.Highlight(file, out.File)
+			body.highlighted = tokens != nil
+
+			if !body.highlighted && len(out.File) > 0 {
+				tokens = []highlight.Token{{Text: string(out.File)}}
+			}
+		}
+
+		numbered := highlight.Lines(tokens)
+		body.rows, err = renderRows("show", "row", len(numbered),
+			func(i int) interface{} {
+				return fileLine{Number: i + 1, Tokens: numbered[i]}
+			})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return body, len(body.rendered) + body.rows.size(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body := v.(*fileBody)
+
+	// the query which shows the file the same way
+	query := lines.Query()
+	if body.markdown && query == "" && body.rendered == "" {
+		query = "?view=source"
+	}
+
 	var page = struct {
 		page
-		Items []*git.LogItem
+		Binary bool
+		Path   string
+		Name   string
+		Crumbs []crumb
+		Hash   string
+
+		Sections []section
+		Range    lineRange
+
+		Highlighted        bool
+		HighlightIntegrity string
+
+		Markdown bool
+		Rendered template.HTML
+		Query    string
 	}{
 		page: page{
 			Repo:      repo,
-			Title:     repo.Name + " - Log",
+			Title:     repo.Name + " - File " + ref + ":" + file,
 			Integrity: integrity,
+			Ref:       ref,
 		},
-		Items: ret,
+		Binary: body.binary,
+		Path:   file,
+		Name:   path.Base(file),
+		Crumbs: crumbs(parentDir(file)),
+		Hash:   hash,
+
+		Sections: body.rows.sections(lines),
+		Range:    lines,
+
+		Highlighted:        body.highlighted,
+		HighlightIntegrity: highlightIntegrity,
+
+		Markdown: body.markdown,
+		Rendered: body.rendered,
+		Query:    query,
 	}
 
 	var b bytes.Buffer
-	if err = templates["log"].Execute(&b, page); err != nil {
+	if err = templates["show"].Execute(&b, page); err != nil {
 		return nil, err
 	}
 
-	if repo.cache != nil {
-		repo.cache.Add(keyLog, timePair{
-			b: b.Bytes(),
-			t: time.Now().UTC(),
-		})
-	}
-
 	return b.Bytes(), nil
 }
 
-func lsCached(repo *repository) ([]byte, error) {
-	if repo.cache != nil {
-		repo.mu.Lock()
-		defer repo.mu.Unlock()
+// blameLine is a numbered line of a blamed file, with its group if it is the
+// first line of the group.
+type blameLine struct {
+	Repo   *repository
+	Number int
+	Group  *git.BlameGroup
+	Text   string
+}
 
-		v, hit := repo.cache.Get(keyLs)
-		if hit && time.Now().UTC().Sub(v.(timePair).t) < repo.d {
-			return v.(timePair).b, nil
-		}
-		repo.cache.Delete(keyLs)
+// blameBody is the cached part of a blame page, which is the same for every
+// range of its lines.
+type blameBody struct {
+	binary bool
+	rows   *rows
+}
+
+func blameCached(repo *repository, ref, file string, lines lineRange) ([]byte,
+	error) {
+	hash, err := repo.Git.Resolve(ref)
+	if err != nil {
+		return nil, err
 	}
 
-	ret, err := repo.Git.Ls()
+	key := cacheKey{kind: keyBlame, ref: ref, hash: hash, path: file}
+
+	v, err := cachedValue(repo, key, func() (interface{}, int, error) {
+		out, err := repo.Git.Blame(hash, file)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var numbered []blameLine
+		for _, g := range out.Groups {
+			for i, text := range g.Lines {
+				line := blameLine{Repo: repo, Number: g.Line + i,
+					Text: text}
+				if i == 0 {
+					line.Group = g
+				}
+				numbered = append(numbered, line)
+			}
+		}
+
+		body := &blameBody{binary: out.Binary}
+		body.rows, err = renderRows("blame", "row", len(numbered),
+			func(i int) interface{} { return numbered[i] })
+		if err != nil {
+			return nil, 0, err
+		}
 
+		return body, body.rows.size(), nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	body := v.(*blameBody)
+
 	var page = struct {
 		page
-		Items []*git.LsItem
+		Binary bool
+		Path   string
+		Name   string
+		Crumbs []crumb
+		Hash   string
+
+		Sections []section
+		Range    lineRange
 	}{
 		page: page{
 			Repo:      repo,
-			Title:     repo.Name + " - Files",
+			Title:     repo.Name + " - Blame " + ref + ":" + file,
 			Integrity: integrity,
+			Ref:       ref,
 		},
-		Items: ret,
+		Binary: body.binary,
+		Path:   file,
+		Name:   path.Base(file),
+		Crumbs: crumbs(parentDir(file)),
+		Hash:   hash,
+
+		Sections: body.rows.sections(lines),
+		Range:    lines,
 	}
 
 	var b bytes.Buffer
-	if err = templates["ls"].Execute(&b, page); err != nil {
+	if err = templates["blame"].Execute(&b, page); err != nil {
 		return nil, err
 	}
 
-	if repo.cache != nil {
-		repo.cache.Add(keyLs, timePair{
-			b: b.Bytes(),
-			t: time.Now().UTC(),
-		})
-	}
-
 	return b.Bytes(), nil
 }
@@ -6,12 +6,18 @@ import (
 )
 
 func init() {
+	integrity = sri(css)
+	highlightIntegrity = sri(highlightCSS)
+}
+
+// Utility: the subresource integrity hash of a stylesheet
+func sri(style string) string {
 	sha := sha512.New()
-	sha.Write([]byte(css))
-	integrity = base64.StdEncoding.EncodeToString(sha.Sum(nil))
+	sha.Write([]byte(style))
+	return base64.StdEncoding.EncodeToString(sha.Sum(nil))
 }
 
-var integrity string
+var integrity, highlightIntegrity string
 
 const css = `body {
 	background-color: #fff;
@@ -39,6 +45,122 @@ tr:hover {
 .desc {
 	color: #444;
 }
+
+.lines {
+	border-collapse: collapse;
+}
+
+.lines td {
+	padding-top: 0;
+	padding-bottom: 0;
+}
+
+.lines pre {
+	margin: 0;
+}
+
+.lines pre:empty::before {
+	content: " ";
+}
+
+.lines .num a {
+	color: #777;
+	text-decoration: none;
+}
+
+.lines tr:target, .lines tbody.marked tr {
+	background-color: #ffc;
+}
+
+.blame .group td {
+	border-top: 1px solid #ddd;
+}
+
+.markdown {
+	max-width: 60em;
+}
+
+.markdown pre {
+	padding: 0.5em;
+	background-color: #f6f6f6;
+	overflow-x: auto;
+}
+
+.markdown table {
+	border-collapse: collapse;
+}
+
+.markdown td, .markdown th {
+	border: 1px solid #ddd;
+}
+
+.markdown blockquote {
+	margin-left: 0;
+	padding-left: 1em;
+	border-left: 3px solid #ddd;
+	color: #444;
+}
+
+.diff {
+	border-collapse: collapse;
+}
+
+.diff td {
+	padding-top: 0;
+	padding-bottom: 0;
+}
+
+.diff pre {
+	margin: 0;
+}
+
+.diff .num {
+	color: #777;
+}
+
+.add {
+	color: #080;
+}
+
+.del {
+	color: #c00;
+}
+
+.diff .add {
+	background-color: #efe;
+}
+
+.diff .del {
+	background-color: #fee;
+}
+
+.diff .hunk {
+	color: #07a;
+}
+
+.split td:nth-child(2) {
+	width: 50%;
+}
+`
+
+// highlightCSS styles the token classes of highlighted files, and is only
+// linked from their pages.
+const highlightCSS = `.highlight .keyword {
+	color: #a626a4;
+}
+
+.highlight .string {
+	color: #50a14f;
+}
+
+.highlight .comment {
+	color: #7f848e;
+	font-style: italic;
+}
+
+.highlight .number {
+	color: #986801;
+}
 `
 
 const layoutTmpl = `<!DOCTYPE html>
@@ -51,17 +173,21 @@ const layoutTmpl = `<!DOCTYPE html>
 			<meta name="description" content="{{index .Repo.Description 0}}">
 		{{end}}{{end}}
 		<link rel="stylesheet" type="text/css" href="/style.css"
-			integrity="sha512-{{.Integrity}}">
+			integrity="sha512-{{.Integrity}}">{{block "head" .}}{{end}}
 		<title>{{.Title}}</title>
 	</head>
 	<body>
 		{{if .Repo}}
-			<p><b>{{.Repo.Name}}</b>{{if .Repo.Bare}} ({{.Repo.Git.Ref}}, bare repository){{end}}</p>
+			<p><b>{{.Repo.Name}}</b> ({{.Ref}}{{if .Repo.Bare}}, bare repository{{end}})</p>
 			{{range .Repo.Description}}
 				<p>{{.}}</p>
+			{{end}}{{if .Repo.CloneURL}}
+			<p>git clone <a href="{{.Repo.CloneURL}}">{{.Repo.CloneURL}}</a></p>
 			{{end}}
-			<p><a href="/{{.Repo.Name}}">Log</a>
-				| <a href="/{{.Repo.Name}}/files">Files</a>
+			<p><a href="/{{.Repo.Name}}">Summary</a>
+				| <a href="/{{.Repo.Name}}/log/{{pathEscape .Ref}}">Log</a>
+				| <a href="/{{.Repo.Name}}/tree/{{pathEscape .Ref}}">Files</a>
+				| <a href="/{{.Repo.Name}}/refs">Refs</a>
 				| <a href="/">&lt;&lt; Repositories</a>{{else}}
 			<p><b>{{.Title}}</b></p>
 		{{end}}
@@ -87,7 +213,9 @@ const reposTmpl = `{{define "content"}}<table>
 	{{end}}</tbody>
 </table>{{end}}`
 
-const logTmpl = `{{define "content"}}<table>
+const logTmpl = `{{define "content"}}{{if .Path}}<p>History of <a href="/{{.Repo.Name}}/tree/{{pathEscape .Ref}}">{{.Repo.Name}}</a>{{range .Crumbs}}
+	/ {{.Name}}{{end}}</p>
+{{end}}<table>
 	<thead>
 		<tr>
 			<th>Date</th>
@@ -108,32 +236,301 @@ const logTmpl = `{{define "content"}}<table>
 			<td class="num">{{.Stat.Deletions}}</td>
 		</tr>
 	{{end}}</tbody>
+</table>{{if or .Ofs .Next}}
+<p>{{if .Ofs}}<a href="/{{.Repo.Name}}/log/{{pathEscape .Ref}}/{{escapePath .Path}}?ofs={{.Prev}}">&lt;&lt; Newer</a>{{end}}
+	{{if and .Ofs .Next}}|{{end}}
+	{{if .Next}}<a href="/{{.Repo.Name}}/log/{{pathEscape .Ref}}/{{escapePath .Path}}?ofs={{.Next}}">Older &gt;&gt;</a>{{end}}</p>{{end}}{{end}}`
+
+const refsTmpl = `{{define "content"}}<table>
+	<thead>
+		<tr>
+			<th>Branch</th>
+			<th>Date</th>
+			<th>Commit Message</th>
+			<th>Author</th>{{if $.Repo.Archives}}
+			<th>Download</th>{{end}}
+		</tr>
+	</thead>
+	<tbody>{{range .Branches}}
+		<tr>
+			<td><a href="/{{$.Repo.Name}}/log/{{pathEscape .Name}}">{{.Name}}</a>{{if ne .Name $.Ref}}
+				(<a href="/{{$.Repo.Name}}/compare/{{pathEscape $.Ref}}...{{pathEscape .Name}}">compare</a>){{end}}</td>
+			<td>{{.Time.UTC.Format "2006-01-02 15:04"}}</td>
+			<td><a href="/{{$.Repo.Name}}/commit/{{.Hash}}">{{.Subject}}</a></td>
+			<td>{{.Author}}</td>{{if $.Repo.Archives}}
+			<td>{{$name := .Name}}{{range $.Repo.Archives}}
+				<a href="/{{$.Repo.Name}}/archive/{{pathEscape $name}}.{{.}}">{{.}}</a>{{end}}</td>{{end}}
+		</tr>
+	{{end}}</tbody>
+</table>
+<br>
+<table>
+	<thead>
+		<tr>
+			<th>Tag</th>
+			<th>Date</th>
+			<th>Commit Message</th>
+			<th>Author</th>{{if $.Repo.Archives}}
+			<th>Download</th>{{end}}
+		</tr>
+	</thead>
+	<tbody>{{range .Tags}}
+		<tr>
+			<td><a href="/{{$.Repo.Name}}/log/{{pathEscape .Name}}">{{.Name}}</a></td>
+			<td>{{.Time.UTC.Format "2006-01-02 15:04"}}</td>
+			<td><a href="/{{$.Repo.Name}}/commit/{{.Hash}}">{{.Subject}}</a></td>
+			<td>{{.Author}}</td>{{if $.Repo.Archives}}
+			<td>{{$name := .Name}}{{range $.Repo.Archives}}
+				<a href="/{{$.Repo.Name}}/archive/{{pathEscape $name}}.{{.}}">{{.}}</a>{{end}}</td>{{end}}
+		</tr>
+	{{end}}</tbody>
 </table>{{end}}`
 
-const lsTmpl = `{{define "content"}}<table>
+const summaryTmpl = `{{define "content"}}{{if .Readme}}<div class="markdown">
+	<p><a href="/{{.Repo.Name}}/file/{{pathEscape .Ref}}/{{escapePath .Readme}}">{{.Readme}}</a></p>
+	{{if .Rendered}}{{.Rendered}}{{else}}<pre>{{.Plain}}</pre>{{end}}
+</div>
+<hr>
+{{end}}<table>
+	<thead>
+		<tr>
+			<th>Date</th>
+			<th>Commit Message</th>
+			<th>Author</th>
+			<th class="num">Files</th>
+			<th class="num">+</th>
+			<th class="num">-</th>
+		</tr>
+	</thead>
+	<tbody>{{range .Commits}}
+		<tr>
+			<td>{{.Time.UTC.Format "2006-01-02 15:04"}}</td>
+			<td><a href="/{{$.Repo.Name}}/commit/{{.Hash}}">{{.Subject}}</a></td>
+			<td>{{.Name}}</td>
+			<td class="num">{{.Stat.Changed}}</td>
+			<td class="num">{{.Stat.Insertions}}</td>
+			<td class="num">{{.Stat.Deletions}}</td>
+		</tr>
+	{{end}}</tbody>
+</table>
+<p><a href="/{{.Repo.Name}}/log/{{pathEscape .Ref}}">Full log &gt;&gt;</a></p>
+<table>
+	<thead>
+		<tr>
+			<th>Branch</th>
+			<th>Date</th>
+			<th>Commit Message</th>
+			<th>Author</th>
+		</tr>
+	</thead>
+	<tbody>{{range .Branches}}
+		<tr>
+			<td><a href="/{{$.Repo.Name}}/log/{{pathEscape .Name}}">{{.Name}}</a></td>
+			<td>{{.Time.UTC.Format "2006-01-02 15:04"}}</td>
+			<td><a href="/{{$.Repo.Name}}/commit/{{.Hash}}">{{.Subject}}</a></td>
+			<td>{{.Author}}</td>
+		</tr>
+	{{end}}</tbody>
+</table>{{if .MoreBranches}}
+<p><a href="/{{.Repo.Name}}/refs">All branches &gt;&gt;</a></p>{{end}}{{if .Tags}}
+<br>
+<table>
+	<thead>
+		<tr>
+			<th>Tag</th>
+			<th>Date</th>
+			<th>Commit Message</th>
+			<th>Author</th>
+		</tr>
+	</thead>
+	<tbody>{{range .Tags}}
+		<tr>
+			<td><a href="/{{$.Repo.Name}}/log/{{pathEscape .Name}}">{{.Name}}</a></td>
+			<td>{{.Time.UTC.Format "2006-01-02 15:04"}}</td>
+			<td><a href="/{{$.Repo.Name}}/commit/{{.Hash}}">{{.Subject}}</a></td>
+			<td>{{.Author}}</td>
+		</tr>
+	{{end}}</tbody>
+</table>{{if .MoreTags}}
+<p><a href="/{{.Repo.Name}}/refs">All tags &gt;&gt;</a></p>{{end}}{{end}}{{end}}`
+
+const treeTmpl = `{{define "content"}}<p><a href="/{{.Repo.Name}}/tree/{{pathEscape .Ref}}">{{.Repo.Name}}</a>{{range .Crumbs}}
+	/ <a href="/{{$.Repo.Name}}/tree/{{pathEscape $.Ref}}/{{escapePath .Path}}">{{.Name}}</a>{{end}}
+	(<a href="/{{.Repo.Name}}/log/{{pathEscape .Ref}}/{{escapePath .Path}}">history</a>)</p>
+<table>
 	<thead>
 		<tr>
 			<th>Mode</th>
 			<th>Name</th>
 			<th class="num">Size</th>
+			<th>History</th>
 		</tr>
 	</thead>
-	<tbody>{{range .Items}}
+	<tbody>{{if .Path}}
+		<tr>
+			<td></td>
+			<td><a href="/{{.Repo.Name}}/tree/{{pathEscape .Ref}}/{{escapePath .Parent}}">..</a></td>
+			<td></td>
+			<td></td>
+		</tr>{{end}}{{range .Items}}
 		<tr>
 			<td>{{.Mode}}</td>
-			{{if $.Repo.Bare}}<td>{{.Name}}</td>{{else}}<td><a href="/{{$.Repo.Name}}/file/{{.Name}}">{{.Name}}</a></td>{{end}}
-			<td class="num">{{.Size}}</td>
+			{{if .IsTree}}<td><a href="/{{$.Repo.Name}}/tree/{{pathEscape $.Ref}}/{{escapePath $.Dir}}{{pathEscape .Name}}">{{.Name}}/</a></td>{{else if .IsBlob}}<td><a href="/{{$.Repo.Name}}/file/{{pathEscape $.Ref}}/{{escapePath $.Dir}}{{pathEscape .Name}}">{{.Name}}</a></td>{{else}}<td>{{.Name}}</td>{{end}}
+			<td class="num">{{if .IsBlob}}{{.Size}}{{end}}</td>
+			<td><a href="/{{$.Repo.Name}}/log/{{pathEscape $.Ref}}/{{escapePath $.Dir}}{{pathEscape .Name}}">log</a></td>
 		</tr>
 	{{end}}</tbody>
 </table>{{end}}`
 
-const commitTmpl = `{{define "content"}}<pre>{{ printf "%s" .Commit.CatFile }}</pre>
-	<hr>
-	<pre>{{ printf "%s" .Commit.DiffStat }}</pre>
-	<hr>
-	<pre>{{ printf "%s" .Commit.Diff }}</pre>{{end}}`
+const commitTmpl = `{{define "content"}}<table>
+	<tr>
+		<th>Author</th>
+		<td>{{.Commit.Author.Name}} &lt;{{.Commit.Author.Email}}&gt;</td>
+		<td>{{.Commit.Author.Time.Format "2006-01-02 15:04:05 -0700"}}</td>
+	</tr>
+	<tr>
+		<th>Committer</th>
+		<td>{{.Commit.Committer.Name}} &lt;{{.Commit.Committer.Email}}&gt;</td>
+		<td>{{.Commit.Committer.Time.Format "2006-01-02 15:04:05 -0700"}}</td>
+	</tr>
+	<tr>
+		<th>Commit</th>
+		<td colspan="2">{{.Commit.Hash}}</td>
+	</tr>
+	<tr>
+		<th>Tree</th>
+		<td colspan="2"><a href="/{{.Repo.Name}}/tree/{{.Commit.Hash}}">{{.Commit.Tree}}</a></td>
+	</tr>{{range $i, $p := .Commit.Parents}}
+	<tr>
+		<th>Parent</th>
+		<td colspan="2"><a href="/{{$.Repo.Name}}/commit/{{.}}">{{.}}</a>{{if gt (len $.Commit.Parents) 1}}
+			(<a href="/{{$.Repo.Name}}/commit/{{$.Hash}}{{($.View.WithParent $i).Query}}">diff</a>){{end}}</td>
+	</tr>{{end}}
+</table>
+<p><b>{{.Commit.Subject}}</b></p>
+{{if .Commit.Body}}<pre>{{.Commit.Body}}</pre>
+{{end}}{{if .Commit.Trailers}}<ul>{{range .Commit.Trailers}}
+	<li>{{.Key}}: {{.Value}}</li>{{end}}
+</ul>
+{{end}}<hr>{{if .Commit.NoDiff}}
+	<p>Diff unavailable.</p>{{else}}{{if gt (len .Commit.Parents) 1}}
+	<p>{{if .View.Parent}}Showing the changes from parent {{.View.Parent}},
+		see the <a href="/{{.Repo.Name}}/commit/{{.Hash}}{{(.View.WithParent -1).Query}}">combined diff</a>{{else}}Showing the combined diff of the files which differ from every parent{{end}}.</p>{{end}}
+	<p><a href="/{{.Repo.Name}}/commit/{{.Hash}}{{.View.ToggleSplit.Query}}">{{if .View.Split}}Unified{{else}}Split{{end}} view</a>
+		| <a href="/{{.Repo.Name}}/commit/{{.Hash}}{{.View.ToggleWhitespace.Query}}">{{if .View.Opts.IgnoreWhitespace}}Show{{else}}Ignore{{end}} whitespace</a></p>
+	{{template "diffstat" .Commit.Diff}}
+	{{if .View.Split}}{{template "splitdiff" .Commit.Diff}}{{else}}{{template "diff" .Commit.Diff}}{{end}}{{end}}{{end}}`
+
+const compareTmpl = `{{define "content"}}<p>Comparing <a href="/{{.Repo.Name}}/log/{{pathEscape .Base}}">{{.Base}}</a>...<a href="/{{.Repo.Name}}/log/{{pathEscape .Head}}">{{.Head}}</a>:
+	{{.Head}} is {{.Compare.Ahead}} commits ahead of and {{.Compare.Behind}} commits behind {{.Base}}, which diverged at
+	<a href="/{{.Repo.Name}}/commit/{{.Compare.MergeBase}}">{{slice .Compare.MergeBase 0 8}}</a>.</p>
+{{if .Compare.Log}}<table>
+	<thead>
+		<tr>
+			<th>Date</th>
+			<th>Commit Message</th>
+			<th>Author</th>
+			<th class="num">Files</th>
+			<th class="num">+</th>
+			<th class="num">-</th>
+		</tr>
+	</thead>
+	<tbody>{{range .Compare.Log}}
+		<tr>
+			<td>{{.Time.UTC.Format "2006-01-02 15:04"}}</td>
+			<td><a href="/{{$.Repo.Name}}/commit/{{.Hash}}">{{.Subject}}</a></td>
+			<td>{{.Name}}</td>
+			<td class="num">{{.Stat.Changed}}</td>
+			<td class="num">{{.Stat.Insertions}}</td>
+			<td class="num">{{.Stat.Deletions}}</td>
+		</tr>
+	{{end}}</tbody>
+</table>{{if lt (len .Compare.Log) .Compare.Ahead}}
+<p>Only the newest {{len .Compare.Log}} commits are shown, see the <a href="/{{.Repo.Name}}/log/{{pathEscape .Head}}">log</a> for more.</p>{{end}}
+<hr>
+<p><a href="/{{.Repo.Name}}/compare/{{pathEscape .Spec}}{{.View.ToggleSplit.Query}}">{{if .View.Split}}Unified{{else}}Split{{end}} view</a>
+	| <a href="/{{.Repo.Name}}/compare/{{pathEscape .Spec}}{{.View.ToggleWhitespace.Query}}">{{if .View.Opts.IgnoreWhitespace}}Show{{else}}Ignore{{end}} whitespace</a></p>
+{{template "diffstat" .Compare.Diff}}
+{{if .View.Split}}{{template "splitdiff" .Compare.Diff}}{{else}}{{template "diff" .Compare.Diff}}{{end}}{{else}}
+<p>There are no changes.</p>{{end}}{{end}}`
+
+const diffTmpl = `{{define "diffstat"}}<table>
+	<tbody>{{range $i, $f := .}}
+		<tr>
+			<td><a href="#f{{$i}}">{{.Name}}</a>{{if .IsRenamed}} (from {{.OldName}}){{else if .IsCopied}} (copy of {{.OldName}}){{end}}</td>
+			<td class="num">{{if .Binary}}binary{{else}}<span class="add">+{{.Insertions}}</span> <span class="del">-{{.Deletions}}</span>{{end}}</td>
+		</tr>
+	{{end}}</tbody>
+</table>
+{{with .Stat}}<p>{{.Changed}} files changed, {{.Insertions}} insertions(+), {{.Deletions}} deletions(-)</p>{{end}}{{end}}
+
+{{define "diffhead"}}<b>{{.Name}}</b>{{if .IsAdded}} (added){{else if .IsDeleted}} (deleted){{else if .IsRenamed}} (renamed from {{.OldName}}, {{.Similarity}}% similar){{else if .IsCopied}} (copied from {{.OldName}}, {{.Similarity}}% similar){{end}}{{if .ModeChanged}} (mode {{.OldMode}} &rarr; {{.NewMode}}){{end}}{{end}}
+
+{{define "diffline"}}{{if .Marks}}{{.Marks}}{{else if .IsAdd}}+{{else if .IsDelete}}-{{else if .IsNoNewline}}\{{else}} {{end}}{{.Text}}{{end}}
+
+{{define "diff"}}{{range $i, $f := .}}<hr>
+<p id="f{{$i}}">{{template "diffhead" .}}</p>{{if .Binary}}
+<p>(Binary file)</p>{{end}}{{if .Hunks}}<table class="diff">
+	<tbody>{{range .Hunks}}
+		<tr class="hunk"><td></td><td></td><td><pre>{{.Header}}</pre></td></tr>{{range .Lines}}
+		<tr{{if .IsAdd}} class="add"{{else if .IsDelete}} class="del"{{end}}><td class="num">{{if .OldLine}}{{.OldLine}}{{end}}</td><td class="num">{{if .NewLine}}{{.NewLine}}{{end}}</td><td><pre>{{template "diffline" .}}</pre></td></tr>{{end}}
+	{{end}}</tbody>
+</table>{{end}}
+{{end}}{{end}}
+
+{{define "splitdiff"}}{{range $i, $f := .}}<hr>
+<p id="f{{$i}}">{{template "diffhead" .}}</p>{{if .Binary}}
+<p>(Binary file)</p>{{end}}{{if .Hunks}}<table class="diff split">
+	<tbody>{{range .Hunks}}
+		<tr class="hunk"><td></td><td colspan="3"><pre>{{.Header}}</pre></td></tr>{{range .Rows}}
+		<tr>{{with .Old}}<td class="num{{if .IsDelete}} del{{end}}">{{if .OldLine}}{{.OldLine}}{{end}}</td><td{{if .IsDelete}} class="del"{{end}}><pre>{{template "diffline" .}}</pre></td>{{else}}<td></td><td></td>{{end}}{{with .New}}<td class="num{{if .IsAdd}} add{{end}}">{{if .NewLine}}{{.NewLine}}{{end}}</td><td{{if .IsAdd}} class="add"{{end}}><pre>{{template "diffline" .}}</pre></td>{{else}}<td></td><td></td>{{end}}</tr>{{end}}
+	{{end}}</tbody>
+</table>{{end}}
+{{end}}{{end}}`
+
+const showTmpl = `{{define "content"}}<p><a href="/{{.Repo.Name}}/tree/{{pathEscape .Ref}}">{{.Repo.Name}}</a>{{range .Crumbs}}
+	/ <a href="/{{$.Repo.Name}}/tree/{{pathEscape $.Ref}}/{{escapePath .Path}}">{{.Name}}</a>{{end}}
+	/ {{.Name}}
+	(<a href="/{{.Repo.Name}}/log/{{pathEscape .Ref}}/{{escapePath .Path}}">history</a>
+	| <a href="/{{.Repo.Name}}/blame/{{pathEscape .Ref}}/{{escapePath .Path}}">blame</a>
+	| <a href="/{{.Repo.Name}}/raw/{{pathEscape .Ref}}/{{escapePath .Path}}">raw</a>{{if .Markdown}}
+	| {{if .Rendered}}<a href="/{{.Repo.Name}}/file/{{pathEscape .Ref}}/{{escapePath .Path}}?view=source">source</a>{{else}}<a href="/{{.Repo.Name}}/file/{{pathEscape .Ref}}/{{escapePath .Path}}">rendered</a>{{end}}{{end}}{{if ne .Ref .Hash}}
+	| <a href="/{{.Repo.Name}}/file/{{.Hash}}/{{escapePath .Path}}{{.Query}}{{with .Range.Anchor}}#{{.}}{{end}}">permalink</a>{{end}})</p>
+{{if .Binary}}
+	<p><b>(Binary file, <a href="/{{.Repo.Name}}/raw/{{pathEscape .Ref}}/{{escapePath .Path}}">download</a>)</b></p>{{else if .Rendered}}<div class="markdown">
+	{{.Rendered}}
+</div>{{else}}<table class="lines{{if .Highlighted}} highlight{{end}}">{{range .Sections}}
+	<tbody{{with .ID}} id="{{.}}" class="marked"{{end}}>{{.Rows}}
+	</tbody>{{end}}
+</table>{{end}}{{end}}
+
+{{define "row"}}
+		<tr id="L{{.Number}}"><td class="num"><a href="#L{{.Number}}">{{.Number}}</a></td><td><pre>{{range .Tokens}}{{if .Class}}<span class="{{.Class}}">{{.Text}}</span>{{else}}{{.Text}}{{end}}{{end}}</pre></td></tr>{{end}}
+
+{{define "head"}}{{if .Highlighted}}
+		<link rel="stylesheet" type="text/css" href="/highlight.css"
+			integrity="sha512-{{.HighlightIntegrity}}">{{end}}{{end}}`
+
+const blameTmpl = `{{define "content"}}<p><a href="/{{.Repo.Name}}/tree/{{pathEscape .Ref}}">{{.Repo.Name}}</a>{{range .Crumbs}}
+	/ <a href="/{{$.Repo.Name}}/tree/{{pathEscape $.Ref}}/{{escapePath .Path}}">{{.Name}}</a>{{end}}
+	/ {{.Name}}
+	(<a href="/{{.Repo.Name}}/file/{{pathEscape .Ref}}/{{escapePath .Path}}">file</a>
+	| <a href="/{{.Repo.Name}}/log/{{pathEscape .Ref}}/{{escapePath .Path}}">history</a>{{if ne .Ref .Hash}}
+	| <a href="/{{.Repo.Name}}/blame/{{.Hash}}/{{escapePath .Path}}{{.Range.Query}}{{with .Range.Anchor}}#{{.}}{{end}}">permalink</a>{{end}})</p>
+{{if .Binary}}
+	<p><b>(Binary file)</b></p>{{else}}<table class="lines blame">{{range .Sections}}
+	<tbody{{with .ID}} id="{{.}}" class="marked"{{end}}>{{.Rows}}
+	</tbody>{{end}}
+</table>{{end}}{{end}}
 
-const showTmpl = `{{define "content"}}{{if .Repo.Bare}}
-	<p><b>(Cannot view files of bare repositories)</b></p>
-	{{else if .Binary}}
-	<p><b>(Binary file)</b></p>{{else}}<pre>{{ printf "%s" .File}}</pre>{{end}}{{end}}`
+{{define "row"}}
+		<tr id="L{{.Number}}"{{if .Group}} class="group"{{end}}>{{with .Group}}
+			<td><a href="/{{$.Repo.Name}}/commit/{{.Hash}}" title="{{.Summary}}">{{slice .Hash 0 8}}</a></td>
+			<td>{{.Time.UTC.Format "2006-01-02"}}</td>
+			<td>{{.Author}}</td>{{else}}
+			<td></td>
+			<td></td>
+			<td></td>{{end}}
+			<td class="num"><a href="#L{{.Number}}">{{.Number}}</a></td>
+			<td><pre>{{.Text}}</pre></td>
+		</tr>{{end}}`
@@ -9,59 +9,101 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/esote/cache"
 	"github.com/esote/gitweb/internal/git"
 	"github.com/esote/gitweb/internal/openbsd"
 	"github.com/esote/graceful"
 )
 
 type config struct {
-	Chroot   string `json:"chroot"`
-	HTTPS    bool   `json:"https"`
-	HTTPSCrt string `json:"https_crt"`
-	HTTPSKey string `json:"https_key"`
-	Port     string `json:"port"`
-	Repos    []struct {
-		Bare          bool     `json:"bare"`
-		CacheDuration string   `json:"cache_duration"`
-		Description   []string `json:"description"`
-		Path          string   `json:"path"`
-		Ref           string   `json:"ref"`
-		Timeout       string   `json:"timeout"`
-	} `json:"repos"`
+	CacheSize    int          `json:"cache_size"`
+	Chroot       string       `json:"chroot"`
+	HTTPS        bool         `json:"https"`
+	HTTPSCrt     string       `json:"https_crt"`
+	HTTPSKey     string       `json:"https_key"`
+	Port         string       `json:"port"`
+	Repos        []repoConfig `json:"repos"`
+	ScanInterval string       `json:"scan_interval"`
+	ScanPath     string       `json:"scan_path"`
+	URL          string       `json:"url"`
 
 	OpenBSD        bool        `json:"openbsd"`
 	OpenBSDUnveils [][2]string `json:"openbsd_unveils"`
+
+	scanInterval time.Duration
+
+	// paths unveiled at startup, nil without OpenBSD restrictions
+	unveiled []string
+}
+
+type repoConfig struct {
+	ArchiveFormats []string `json:"archive_formats"`
+	ArchiveMaxSize int64    `json:"archive_max_size"`
+	ArchiveTimeout string   `json:"archive_timeout"`
+	Backend        string   `json:"backend"`
+	Bare           bool     `json:"bare"`
+	CacheDuration  string   `json:"cache_duration"`
+	CloneTimeout   string   `json:"clone_timeout"`
+	Description    []string `json:"description"`
+	MaxObjectSize  int64    `json:"max_object_size"`
+	PageSize       int      `json:"page_size"`
+	Path           string   `json:"path"`
+	Ref            string   `json:"ref"`
+	Timeout        string   `json:"timeout"`
 }
 
 type page struct {
 	Repo      *repository
 	Title     string
 	Integrity string
+	Ref       string
 }
 
 type repository struct {
+	Archives    []string
 	Bare        bool
+	CloneURL    string
 	Description []string
 	Git         *git.Git
 	Name        string
 
-	cache cache.Cache
-	d     time.Duration
-	mu    sync.Mutex
+	archiveMax     int64
+	archiveTimeout time.Duration
+	cache          bool
+	cloneTimeout   time.Duration
+	conf           repoConfig
+	d              time.Duration
+	generation     string
+	pageSize       int
+}
+
+// Content types of the archive formats which may be allowed.
+var archiveTypes = map[string]string{
+	"tar.gz": "application/gzip",
+	"zip":    "application/zip",
 }
 
 var index []byte
 var repos map[string]*repository
 var templates map[string]*template.Template
 
+// reposMu guards index and repos, which are replaced when rescanning.
+var reposMu sync.RWMutex
+
+var funcs = template.FuncMap{
+	"pathEscape": url.PathEscape,
+	"escapePath": escapePath,
+}
+
 func main() {
 	file := "config.json"
 
@@ -76,7 +118,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	if err := initializeRepos(conf); err != nil {
+	if err := restrict(conf, file); err != nil {
 		log.Fatal(err)
 	}
 
@@ -84,10 +126,19 @@ func main() {
 		log.Fatal(err)
 	}
 
+	pages = newLRU(conf.CacheSize)
+
+	if err := initializeRepos(conf); err != nil {
+		log.Fatal(err)
+	}
+
+	go watch(file, conf)
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", multiplex)
-	mux.HandleFunc("/style.css", cssHandler)
+	mux.HandleFunc("/style.css", cssHandler(css))
+	mux.HandleFunc("/highlight.css", cssHandler(highlightCSS))
 
 	cfg := &tls.Config{
 		MinVersion: tls.VersionTLS12,
@@ -136,23 +187,25 @@ func headers(w http.ResponseWriter) {
 	w.Header().Set("X-XSS-Protection", "1")
 }
 
-func cssHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		httpError(w, http.StatusMethodNotAllowed)
-		return
-	}
+func cssHandler(style string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed)
+			return
+		}
 
-	headers(w)
-	w.Header().Set("Content-Security-Policy", "default-src 'none';")
-	w.Header().Set("Content-Type", "text/css")
+		headers(w)
+		w.Header().Set("Content-Security-Policy", "default-src 'none';")
+		w.Header().Set("Content-Type", "text/css")
 
-	if _, err := w.Write([]byte(css)); err != nil {
-		log.Println(err)
+		if _, err := w.Write([]byte(style)); err != nil {
+			log.Println(err)
+		}
 	}
 }
 
 func multiplex(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
 		httpError(w, http.StatusMethodNotAllowed)
 		return
 	}
@@ -161,15 +214,31 @@ func multiplex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Security-Policy", "default-src 'none';"+
 		"style-src 'self';")
 
-	paths := strings.Split(r.URL.Path[1:], "/")
+	// refs may contain slashes, so split before unescaping
+	paths := strings.Split(r.URL.EscapedPath()[1:], "/")
+
+	for i := range paths {
+		var err error
+		if paths[i], err = url.PathUnescape(paths[i]); err != nil {
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+	}
 
 	if len(paths) < 1 || paths[0] == "" {
 		httpIndex(w)
 		return
 	}
 
+	reposMu.RLock()
 	repo, ok := repos[paths[0]]
 
+	if !ok {
+		// clone URLs conventionally end in .git
+		repo, ok = repos[strings.TrimSuffix(paths[0], ".git")]
+	}
+	reposMu.RUnlock()
+
 	if !ok {
 		httpError(w, http.StatusNotFound)
 		return
@@ -177,15 +246,42 @@ func multiplex(w http.ResponseWriter, r *http.Request) {
 
 	l := len(paths)
 
+	// only git clients send requests with bodies
+	pack := l == 2 && paths[1] == "git-upload-pack"
+	if (r.Method == http.MethodPost) != pack {
+		httpError(w, http.StatusMethodNotAllowed)
+		return
+	}
+
 	switch {
 	case l == 1:
-		httpLog(w, r, repo)
-	case l == 2 && paths[1] == "files":
-		httpLs(w, r, repo)
-	case l >= 3 && paths[1] == "file":
-		httpFile(w, r, repo, strings.Join(paths[2:], "/"))
+		httpSummary(w, r, repo)
+	case l == 2 && paths[1] == "log":
+		httpLog(w, r, repo, repo.Git.Ref(), "")
+	case l >= 3 && paths[1] == "log":
+		httpLog(w, r, repo, paths[2], strings.Join(paths[3:], "/"))
+	case l == 2 && (paths[1] == "files" || paths[1] == "tree"):
+		httpTree(w, r, repo, repo.Git.Ref(), "")
+	case l >= 3 && paths[1] == "tree":
+		httpTree(w, r, repo, paths[2], strings.Join(paths[3:], "/"))
+	case l == 2 && paths[1] == "refs":
+		httpRefs(w, r, repo)
+	case l >= 4 && paths[1] == "file":
+		httpFile(w, r, repo, paths[2], strings.Join(paths[3:], "/"))
+	case l >= 4 && paths[1] == "blame":
+		httpBlame(w, r, repo, paths[2], strings.Join(paths[3:], "/"))
+	case l >= 4 && paths[1] == "raw":
+		httpRaw(w, r, repo, paths[2], strings.Join(paths[3:], "/"))
+	case l >= 3 && paths[1] == "archive":
+		httpArchive(w, r, repo, strings.Join(paths[2:], "/"))
+	case l == 3 && paths[1] == "info" && paths[2] == "refs":
+		httpInfoRefs(w, r, repo)
+	case pack:
+		httpUploadPack(w, r, repo)
 	case l >= 3 && paths[1] == "commit":
-		httpCommit(w, r, repo, paths[2])
+		httpCommit(w, r, repo, strings.Join(paths[2:], "/"))
+	case l >= 3 && paths[1] == "compare":
+		httpCompare(w, r, repo, strings.Join(paths[2:], "/"))
 	default:
 		httpError(w, http.StatusNotFound)
 	}
@@ -212,46 +308,89 @@ func parseConfig(path string) (*config, error) {
 		return nil, errors.New("missing HTTPS crt or key")
 	}
 
+	if conf.CacheSize < 0 {
+		return nil, errors.New("negative cache size")
+	}
+
+	if conf.CacheSize == 0 {
+		conf.CacheSize = 64 << 20
+	}
+
+	if conf.ScanInterval == "" {
+		conf.scanInterval = 5 * time.Minute
+	} else {
+		conf.scanInterval, err = time.ParseDuration(conf.ScanInterval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &conf, nil
+}
+
+// Apply the process restrictions of the configuration. This can only be done
+// once, so later configuration reloads cannot change these settings.
+func restrict(conf *config, file string) error {
+	// pledge doesn't allow chroot, and paths are unveiled inside the chroot
+	if conf.Chroot != "" {
+		if err := syscall.Chroot(conf.Chroot); err != nil {
+			return err
+		}
+	}
+
 	if conf.OpenBSD {
-		u := conf.OpenBSDUnveils
+		// the configuration file is read again on reload
+		u := append(conf.OpenBSDUnveils, [2]string{file, "r"})
 
 		if conf.HTTPS {
 			u = append(u, [2]string{conf.HTTPSCrt, "r"},
 				[2]string{conf.HTTPSKey, "r"})
 		}
 
+		// scanned repositories use the exec backend unless they say
+		// otherwise, which is only known once they are read
+		clone := conf.ScanPath != ""
+
 		for _, r := range conf.Repos {
 			u = append(u, [2]string{r.Path, "r"})
+			clone = clone || r.Backend != git.BackendNative
 		}
 
-		if err := openbsd.Secure(u); err != nil {
-			return nil, err
+		if conf.ScanPath != "" {
+			u = append(u, [2]string{conf.ScanPath, "r"})
 		}
-	}
 
-	if conf.Chroot != "" {
-		if err := syscall.Chroot(conf.Chroot); err != nil {
-			return nil, err
+		conf.unveiled = make([]string, len(u))
+		for i, path := range u {
+			conf.unveiled[i] = path[0]
 		}
+
+		return openbsd.Secure(u, clone)
 	}
 
-	return &conf, nil
+	return nil
 }
 
 func initializeTmpls() (err error) {
 	var tmpls = []struct {
 		name, format string
 	}{
+		{"blame", blameTmpl},
 		{"commit", commitTmpl},
+		{"compare", compareTmpl},
 		{"log", logTmpl},
-		{"ls", lsTmpl},
+		{"refs", refsTmpl},
+		{"repos", reposTmpl},
 		{"show", showTmpl},
+		{"summary", summaryTmpl},
+		{"tree", treeTmpl},
 	}
 
 	templates = make(map[string]*template.Template, len(tmpls))
 
 	for _, tmpl := range tmpls {
-		templates[tmpl.name], err = template.New(tmpl.name).Parse(tmpl.format)
+		templates[tmpl.name], err = template.New(tmpl.name).
+			Funcs(funcs).Parse(tmpl.format)
 
 		if err != nil {
 			return
@@ -262,18 +401,19 @@ func initializeTmpls() (err error) {
 		if err != nil {
 			return
 		}
-	}
 
-	tmpl, err := template.New("repos").Parse(reposTmpl)
-	if err != nil {
-		return
-	}
+		templates[tmpl.name], err = templates[tmpl.name].Parse(diffTmpl)
 
-	tmpl, err = tmpl.Parse(layoutTmpl)
-	if err != nil {
-		return
+		if err != nil {
+			return
+		}
 	}
 
+	return
+}
+
+// Render the index page listing the repositories.
+func renderIndex(repos map[string]*repository) ([]byte, error) {
 	var page = struct {
 		page
 		Repos map[string]*repository
@@ -287,56 +427,196 @@ func initializeTmpls() (err error) {
 	}
 
 	var b bytes.Buffer
-	if err = tmpl.Execute(&b, page); err != nil {
-		return
+	if err := templates["repos"].Execute(&b, page); err != nil {
+		return nil, err
 	}
-	index = b.Bytes()
-	return
+	return b.Bytes(), nil
 }
+
+// Load the repositories of conf and render the index page, then serve both at
+// once. On error the repositories being served are left unchanged.
 func initializeRepos(conf *config) error {
-	repos = make(map[string]*repository, len(conf.Repos))
+	reposMu.RLock()
+	old := repos
+	reposMu.RUnlock()
 
+	m, err := loadRepos(conf, old)
+	if err != nil {
+		return err
+	}
+
+	b, err := renderIndex(m)
+	if err != nil {
+		return err
+	}
+
+	reposMu.Lock()
+	repos, index = m, b
+	reposMu.Unlock()
+
+	if old != nil {
+		logChanges(old, m)
+	}
+	return nil
+}
+
+// Load the configured and scanned repositories. Repositories in old with an
+// unchanged configuration are kept as they are, along with their caches.
+// Scanned repositories with invalid settings are skipped.
+func loadRepos(conf *config, old map[string]*repository) (map[string]*repository, error) {
+	ret := make(map[string]*repository, len(conf.Repos))
+
+	add := func(c repoConfig) error {
+		r, err := newRepository(conf, c)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := ret[r.Name]; ok {
+			log.Printf("skipping %s, name %s already used\n", c.Path,
+				r.Name)
+			return nil
+		}
+
+		if prev, ok := old[r.Name]; ok && r.CloneURL == prev.CloneURL &&
+			reflect.DeepEqual(prev.conf, c) {
+			r = prev
+		}
+
+		ret[r.Name] = r
+		return nil
+	}
+
+	for _, c := range conf.Repos {
+		if err := add(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.ScanPath == "" {
+		return ret, nil
+	}
+
+	scanned, err := scanRepos(conf.ScanPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range scanned {
+		if err := add(c); err != nil {
+			log.Println(c.Path, err)
+		}
+	}
+
+	return ret, nil
+}
+
+func newRepository(conf *config, c repoConfig) (*repository, error) {
 	var err error
 	const (
-		defaultTimeout       = 2 * time.Second
-		defaultCacheDuration = time.Hour
+		defaultTimeout     = 2 * time.Second
+		defaultPageSize    = 100
+		defaultArchiveTime = time.Minute
+		defaultCloneTime   = 10 * time.Minute
+		defaultMaxObject   = 128 << 20
 	)
 
-	for _, c := range conf.Repos {
-		var timeout = defaultTimeout
+	var timeout = defaultTimeout
 
-		if c.Timeout != "" {
-			timeout, err = time.ParseDuration(c.Timeout)
-			if err != nil {
-				return err
-			}
+	if c.Timeout != "" {
+		timeout, err = time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		r := repository{
-			Bare:        c.Bare,
-			Description: c.Description,
-			Git:         git.NewGit(c.Path, c.Ref, timeout),
-			Name:        filepath.Base(c.Path),
-		}
+	backend := c.Backend
+	if backend == "" {
+		backend = git.BackendExec
+	}
+
+	maxObject := c.MaxObjectSize
+	if maxObject < 0 {
+		return nil, errors.New("negative max object size")
+	}
+
+	if maxObject == 0 {
+		maxObject = defaultMaxObject
+	}
 
-		if r.Bare {
-			r.Name = strings.TrimSuffix(r.Name, ".git")
+	g, err := git.NewGit(c.Path, c.Ref, backend, timeout, maxObject)
+	if err != nil {
+		return nil, err
+	}
+
+	// pages rendered by another run of gitweb, or before the repository's
+	// settings changed, may differ
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	r := repository{
+		Archives:    c.ArchiveFormats,
+		Bare:        c.Bare,
+		Description: c.Description,
+		Git:         g,
+		Name:        filepath.Base(c.Path),
+		archiveMax:  c.ArchiveMaxSize,
+		conf:        c,
+		generation:  generation,
+		pageSize:    c.PageSize,
+	}
+
+	for _, format := range r.Archives {
+		if _, ok := archiveTypes[format]; !ok {
+			return nil, errors.New("unknown archive format " + format)
 		}
+	}
 
-		if c.CacheDuration == "" {
-			r.d = defaultCacheDuration
-		} else {
-			r.d, err = time.ParseDuration(c.CacheDuration)
-			if err != nil {
-				return err
-			}
+	if c.ArchiveTimeout == "" {
+		r.archiveTimeout = defaultArchiveTime
+	} else {
+		r.archiveTimeout, err = time.ParseDuration(c.ArchiveTimeout)
+		if err != nil {
+			return nil, err
 		}
-		if r.d != 0 {
-			r.cache = cache.NewLRU(cacheCount)
+	}
+
+	if r.pageSize < 0 {
+		return nil, errors.New("negative page size")
+	}
+
+	if r.pageSize == 0 {
+		r.pageSize = defaultPageSize
+	}
+
+	if r.Bare {
+		r.Name = strings.TrimSuffix(r.Name, ".git")
+	}
+
+	// cloning runs git upload-pack
+	if conf.URL != "" && backend == git.BackendExec {
+		r.CloneURL = strings.TrimSuffix(conf.URL, "/") + "/" + r.Name
+	}
+
+	if c.CloneTimeout == "" {
+		r.cloneTimeout = defaultCloneTime
+	} else {
+		r.cloneTimeout, err = time.ParseDuration(c.CloneTimeout)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		repos[r.Name] = &r
+	// pages are cached until their ref moves, unless a maximum age is
+	// set, and a zero duration disables the cache
+	if c.CacheDuration == "" {
+		r.cache = true
+	} else {
+		r.d, err = time.ParseDuration(c.CacheDuration)
+		if err != nil {
+			return nil, err
+		}
+		r.cache = r.d != 0
 	}
 
-	return nil
+	return &r, nil
 }
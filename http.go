@@ -1,25 +1,64 @@
 package main
 
 import (
-	"bytes"
+	"compress/gzip"
 	"context"
+	"html/template"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/esote/gitweb/internal/git"
 )
 
 func httpError(w http.ResponseWriter, status int) {
+	// errors may not last, unlike the pages they replace
+	w.Header().Del("Cache-Control")
+	w.Header().Del("ETag")
 	http.Error(w, http.StatusText(status), status)
 }
 
-func httpLog(w http.ResponseWriter, r *http.Request, repo *repository) {
-	b, err := logCached(repo)
+// immutableControl marks responses addressed by a hash of their contents,
+// which never change.
+const immutableControl = "public, max-age=31536000, immutable"
+
+// Answer the request with 304 Not Modified if the client already has the page
+// of a commit. The page also depends on the settings of the repository and on
+// gitweb itself, so clients revalidate it against an ETag of the commit and
+// the generation of the repository. It is called once the page has rendered,
+// so invalid requests get their error instead. Reports whether the request was
+// answered.
+func unchanged(w http.ResponseWriter, r *http.Request, repo *repository,
+	etag string) bool {
+	etag = `"` + repo.generation + "-" + etag + `"`
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+func httpSummary(w http.ResponseWriter, r *http.Request, repo *repository) {
+	b, err := summaryCached(repo)
 	if err != nil {
-		if err == context.DeadlineExceeded {
+		switch err {
+		case git.ErrInvalidRef:
+			httpError(w, http.StatusNotFound)
+		case git.ErrTooLarge:
+			httpError(w, http.StatusRequestEntityTooLarge)
+		case context.DeadlineExceeded:
 			httpError(w, http.StatusRequestTimeout)
-		} else {
+		default:
 			httpError(w, http.StatusInternalServerError)
 			log.Println(err)
 		}
@@ -31,8 +70,74 @@ func httpLog(w http.ResponseWriter, r *http.Request, repo *repository) {
 	}
 }
 
-func httpLs(w http.ResponseWriter, r *http.Request, repo *repository) {
-	b, err := lsCached(repo)
+func httpLog(w http.ResponseWriter, r *http.Request, repo *repository, ref, file string) {
+	file, ok := cleanPath(file)
+	if !ok {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	ofs, ok := offset(r)
+	if !ok {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	b, err := logCached(repo, ref, file, ofs)
+	if err != nil {
+		switch err {
+		case git.ErrInvalidRef, git.ErrNotExist:
+			httpError(w, http.StatusNotFound)
+		case context.DeadlineExceeded:
+			httpError(w, http.StatusRequestTimeout)
+		default:
+			httpError(w, http.StatusInternalServerError)
+			log.Println(err)
+		}
+		return
+	}
+
+	if repo.Git.IsHash(ref) && unchanged(w, r, repo, ref) {
+		return
+	}
+
+	if _, err = w.Write(b); err != nil {
+		log.Println(err)
+	}
+}
+
+func httpTree(w http.ResponseWriter, r *http.Request, repo *repository, ref, dir string) {
+	dir, ok := cleanPath(dir)
+	if !ok {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	b, err := treeCached(repo, ref, dir)
+	if err != nil {
+		switch err {
+		case git.ErrInvalidRef, git.ErrNotExist:
+			httpError(w, http.StatusNotFound)
+		case context.DeadlineExceeded:
+			httpError(w, http.StatusRequestTimeout)
+		default:
+			httpError(w, http.StatusInternalServerError)
+			log.Println(err)
+		}
+		return
+	}
+
+	if repo.Git.IsHash(ref) && unchanged(w, r, repo, ref) {
+		return
+	}
+
+	if _, err = w.Write(b); err != nil {
+		log.Println(err)
+	}
+}
+
+func httpRefs(w http.ResponseWriter, r *http.Request, repo *repository) {
+	b, err := refsCached(repo)
 	if err != nil {
 		if err == context.DeadlineExceeded {
 			httpError(w, http.StatusRequestTimeout)
@@ -49,12 +154,89 @@ func httpLs(w http.ResponseWriter, r *http.Request, repo *repository) {
 }
 
 func httpCommit(w http.ResponseWriter, r *http.Request, repo *repository, hash string) {
-	out, err := repo.Git.Commit(hash)
+	full, err := repo.Git.Resolve(hash)
+	if err != nil {
+		switch err {
+		case git.ErrInvalidRef:
+			httpError(w, http.StatusNotFound)
+		case context.DeadlineExceeded:
+			httpError(w, http.StatusRequestTimeout)
+		default:
+			httpError(w, http.StatusInternalServerError)
+			log.Println(err)
+		}
+		return
+	}
+
+	// abbreviated hashes and ref names redirect to the full hash
+	if full != hash {
+		u := url.URL{
+			Path:     "/" + repo.Name + "/commit/" + full,
+			RawQuery: r.URL.RawQuery,
+		}
+
+		http.Redirect(w, r, u.String(), http.StatusFound)
+		return
+	}
+
+	view, ok := parseDiffView(r)
+	if !ok {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
 
+	b, err := commitCached(repo, hash, view)
 	if err != nil {
 		switch err {
 		case git.ErrInvalidHash:
 			httpError(w, http.StatusBadRequest)
+		case git.ErrInvalidRef, git.ErrNoParent:
+			httpError(w, http.StatusNotFound)
+		case git.ErrUnsupported:
+			httpError(w, http.StatusNotImplemented)
+		case context.DeadlineExceeded:
+			httpError(w, http.StatusRequestTimeout)
+		default:
+			httpError(w, http.StatusInternalServerError)
+			log.Println(err)
+		}
+		return
+	}
+
+	if unchanged(w, r, repo, hash) {
+		return
+	}
+
+	if _, err = w.Write(b); err != nil {
+		log.Println(err)
+	}
+}
+
+func httpCompare(w http.ResponseWriter, r *http.Request, repo *repository, spec string) {
+	i := strings.Index(spec, "...")
+	if i <= 0 || i+3 == len(spec) {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	base, head := spec[:i], spec[i+3:]
+
+	view, ok := parseDiffView(r)
+	if !ok {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	// only commits have parents to choose from
+	view.Parent = 0
+
+	b, err := compareCached(repo, base, head, view)
+	if err != nil {
+		switch err {
+		case git.ErrInvalidRef, git.ErrUnrelated:
+			httpError(w, http.StatusNotFound)
+		case git.ErrUnsupported:
+			httpError(w, http.StatusNotImplemented)
 		case context.DeadlineExceeded:
 			httpError(w, http.StatusRequestTimeout)
 		default:
@@ -64,75 +246,622 @@ func httpCommit(w http.ResponseWriter, r *http.Request, repo *repository, hash s
 		return
 	}
 
-	var page = struct {
-		page
-		Commit *git.Commit
-	}{
-		page: page{
-			Repo:      repo,
-			Title:     repo.Name + " - Commit " + hash,
-			Integrity: integrity,
-		},
-		Commit: out,
+	if repo.Git.IsHash(base) && repo.Git.IsHash(head) && unchanged(w, r, repo, base+head) {
+		return
 	}
 
-	var b bytes.Buffer
-	if err = templates["commit"].Execute(&b, page); err != nil {
+	if _, err = w.Write(b); err != nil {
 		log.Println(err)
-		httpError(w, http.StatusInternalServerError)
+	}
+}
+
+func httpFile(w http.ResponseWriter, r *http.Request, repo *repository, ref, file string) {
+	file, ok := cleanPath(file)
+	if !ok {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	lines, ok := parseLineRange(r)
+	if !ok {
+		httpError(w, http.StatusBadRequest)
 		return
 	}
-	if _, err = io.Copy(w, &b); err != nil {
+
+	var source bool
+	switch r.URL.Query().Get("view") {
+	case "":
+	case "source":
+		source = true
+	default:
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	b, err := fileCached(repo, ref, file, lines, source)
+	if err != nil {
+		switch err {
+		case git.ErrInvalidRef:
+			httpError(w, http.StatusNotFound)
+		case git.ErrNotExist:
+			httpError(w, http.StatusBadRequest)
+		case git.ErrTooLarge:
+			httpError(w, http.StatusRequestEntityTooLarge)
+		case context.DeadlineExceeded:
+			httpError(w, http.StatusRequestTimeout)
+		default:
+			httpError(w, http.StatusInternalServerError)
+			log.Println(err)
+		}
+		return
+	}
+
+	if repo.Git.IsHash(ref) && unchanged(w, r, repo, ref) {
+		return
+	}
+
+	if _, err = w.Write(b); err != nil {
 		log.Println(err)
 	}
 }
 
-func httpFile(w http.ResponseWriter, r *http.Request, repo *repository, file string) {
-	if repo.Bare {
-		httpError(w, http.StatusNotFound)
+func httpBlame(w http.ResponseWriter, r *http.Request, repo *repository, ref, file string) {
+	file, ok := cleanPath(file)
+	if !ok {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	lines, ok := parseLineRange(r)
+	if !ok {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	b, err := blameCached(repo, ref, file, lines)
+	if err != nil {
+		switch err {
+		case git.ErrInvalidRef:
+			httpError(w, http.StatusNotFound)
+		case git.ErrNotExist:
+			httpError(w, http.StatusBadRequest)
+		case git.ErrUnsupported:
+			httpError(w, http.StatusNotImplemented)
+		case context.DeadlineExceeded:
+			httpError(w, http.StatusRequestTimeout)
+		default:
+			httpError(w, http.StatusInternalServerError)
+			log.Println(err)
+		}
 		return
 	}
 
-	out, err := repo.Git.Show(file)
+	if repo.Git.IsHash(ref) && unchanged(w, r, repo, ref) {
+		return
+	}
 
+	if _, err = w.Write(b); err != nil {
+		log.Println(err)
+	}
+}
+
+func httpRaw(w http.ResponseWriter, r *http.Request, repo *repository, ref, file string) {
+	file, ok := cleanPath(file)
+	if !ok {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	out, err := repo.Git.Raw(ref, file, repo.archiveMax, repo.archiveTimeout)
 	if err != nil {
 		switch err {
+		case git.ErrInvalidRef:
+			httpError(w, http.StatusNotFound)
 		case git.ErrNotExist:
 			httpError(w, http.StatusBadRequest)
+		case git.ErrTooLarge:
+			httpError(w, http.StatusRequestEntityTooLarge)
+		case context.DeadlineExceeded:
+			httpError(w, http.StatusRequestTimeout)
+		default:
+			httpError(w, http.StatusInternalServerError)
+			log.Println(err)
+		}
+		return
+	}
+	defer out.Close()
+
+	// repository content must never run in the context of gitweb
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("ETag", `"`+out.Hash+`"`)
+
+	if repo.Git.IsHash(ref) {
+		w.Header().Set("Cache-Control", immutableControl)
+	}
+
+	// handles Content-Type, Content-Length, ranges and conditional requests,
+	// streaming only the requested part of the file
+	http.ServeContent(w, r, path.Base(file), time.Time{}, out)
+}
+
+func httpArchive(w http.ResponseWriter, r *http.Request, repo *repository, name string) {
+	var ref, format string
+
+	for _, f := range repo.Archives {
+		if strings.HasSuffix(name, "."+f) {
+			ref, format = strings.TrimSuffix(name, "."+f), f
+			break
+		}
+	}
+
+	if format == "" {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	base := repo.Name + "-" + strings.Replace(ref, "/", "-", -1)
+
+	// ref names may contain quotes and other special characters
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": base + "." + format}))
+	w.Header().Set("Content-Type", archiveTypes[format])
+
+	// errors can only be reported before the archive starts streaming
+	aw := &countWriter{w: w}
+	err := repo.Git.Archive(aw, ref, format, base+"/", repo.archiveMax,
+		repo.archiveTimeout)
+
+	if err != nil && aw.n == 0 {
+		w.Header().Del("Content-Disposition")
+
+		switch err {
+		case git.ErrInvalidRef:
+			httpError(w, http.StatusNotFound)
+		case git.ErrTooLarge:
+			httpError(w, http.StatusRequestEntityTooLarge)
+		case git.ErrUnsupported:
+			httpError(w, http.StatusNotImplemented)
 		case context.DeadlineExceeded:
 			httpError(w, http.StatusRequestTimeout)
 		default:
 			httpError(w, http.StatusInternalServerError)
 			log.Println(err)
 		}
+	} else if err != nil {
+		// too late for an error status, so make sure the client sees a
+		// broken download rather than a truncated archive
+		log.Println(err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func httpInfoRefs(w http.ResponseWriter, r *http.Request, repo *repository) {
+	// the dumb protocol is not supported
+	if r.URL.Query().Get("service") != "git-upload-pack" {
+		httpError(w, http.StatusForbidden)
 		return
 	}
 
-	var page = struct {
-		page
-		git.Show
-	}{
-		page: page{
-			Repo:      repo,
-			Title:     repo.Name + " - File " + file,
-			Integrity: integrity,
-		},
-		Show: out,
+	// upload-pack is not available without git
+	if repo.conf.Backend == git.BackendNative {
+		httpError(w, http.StatusNotImplemented)
+		return
 	}
 
-	var b bytes.Buffer
-	if err = templates["show"].Execute(&b, page); err != nil {
+	protocol := r.Header.Get("Git-Protocol")
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type",
+		"application/x-git-upload-pack-advertisement")
+
+	// protocol version 2 clients do not expect the service pkt-line
+	if !strings.Contains(protocol, "version=2") {
+		if _, err := io.WriteString(w,
+			"001e# service=git-upload-pack\n0000"); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	err := repo.Git.UploadPack(w, nil, true, protocol, repo.cloneTimeout)
+	if err != nil {
 		log.Println(err)
-		httpError(w, http.StatusInternalServerError)
+	}
+}
+
+func httpUploadPack(w http.ResponseWriter, r *http.Request, repo *repository) {
+	if repo.conf.Backend == git.BackendNative {
+		httpError(w, http.StatusNotImplemented)
 		return
 	}
-	if _, err = io.Copy(w, &b); err != nil {
+
+	var body io.Reader = r.Body
+
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+
+	err := repo.Git.UploadPack(w, body, false, r.Header.Get("Git-Protocol"),
+		repo.cloneTimeout)
+	if err != nil {
 		log.Println(err)
 	}
 }
 
 func httpIndex(w http.ResponseWriter) {
-	if _, err := w.Write(index); err != nil {
+	reposMu.RLock()
+	b := index
+	reposMu.RUnlock()
+
+	if _, err := w.Write(b); err != nil {
 		log.Println(err)
 	}
 }
+
+// crumb is a single link in the breadcrumbs of a path.
+type crumb struct {
+	Name string
+	Path string
+}
+
+// Split a repository path into breadcrumbs, each linking to its full prefix.
+func crumbs(p string) []crumb {
+	if p == "" {
+		return nil
+	}
+
+	parts := strings.Split(p, "/")
+	ret := make([]crumb, len(parts))
+
+	for i, part := range parts {
+		ret[i] = crumb{
+			Name: part,
+			Path: strings.Join(parts[:i+1], "/"),
+		}
+	}
+
+	return ret
+}
+
+// Retrieve the parent directory of a repository path, "" being the root.
+func parentDir(p string) string {
+	if dir := path.Dir(p); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// readmeExts are the extensions of README files in order of preference, those
+// rendered as Markdown first.
+var readmeExts = []string{".md", ".markdown", ".mdown", ".mkd", "", ".txt"}
+
+// Find the preferred README among the entries of a directory, "" if none. Case
+// is ignored, and other extensions are only used if there is no better match.
+func findReadme(items []*git.LsItem) string {
+	best, rank := "", len(readmeExts)+1
+
+	for _, item := range items {
+		lower := strings.ToLower(item.Name)
+		if !item.IsBlob() || item.Mode&os.ModeSymlink != 0 ||
+			lower != "readme" && !strings.HasPrefix(lower, "readme.") {
+			continue
+		}
+
+		i := len(readmeExts)
+		for j, ext := range readmeExts {
+			if lower == "readme"+ext {
+				i = j
+				break
+			}
+		}
+
+		if i < rank {
+			best, rank = item.Name, i
+		}
+	}
+
+	return best
+}
+
+// Check if a file is rendered as Markdown by its extension.
+func isMarkdown(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown", ".mdown", ".mkd":
+		return true
+	}
+	return false
+}
+
+// Rewrite the relative links of a Markdown file to the raw files of ref they
+// point to, resolved against the directory of the file. Links to directories,
+// ending in a slash, point to their tree instead. Links which escape the root
+// are left as they are.
+func rawLinks(repo *repository, ref, file string) func(string) string {
+	return func(dest string) string {
+		u, err := url.Parse(dest)
+		if err != nil || u.Path == "" {
+			return dest
+		}
+
+		p := u.Path
+		if !strings.HasPrefix(p, "/") {
+			p = path.Join(parentDir(file), p)
+		}
+
+		// unlike URLs of pages, links may step through "." and ".."
+		if p = path.Clean(p); p == "." {
+			p = ""
+		}
+
+		p, ok := cleanPath(p)
+		if !ok {
+			return dest
+		}
+
+		kind := "/raw/"
+		if p == "" || strings.HasSuffix(u.Path, "/") {
+			kind = "/tree/"
+		}
+
+		// refs may contain slashes, which must stay escaped
+		u.Path = "/" + repo.Name + kind + ref + "/" + p
+		u.RawPath = "/" + url.PathEscape(repo.Name) + kind +
+			url.PathEscape(ref) + "/" + escapePath(p)
+
+		return u.String()
+	}
+}
+
+// Escape each segment of a repository path for use in a URL path, keeping the
+// slashes between them.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Check a repository path from the URL, rejecting empty, "." and ".." segments
+// so that each page has one URL. Slashes around the path are ignored.
+func cleanPath(p string) (string, bool) {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return "", true
+	}
+
+	for _, s := range strings.Split(p, "/") {
+		if s == "" || s == "." || s == ".." {
+			return "", false
+		}
+	}
+
+	return p, true
+}
+
+// Parse the log offset query, which defaults to zero.
+func offset(r *http.Request) (int, bool) {
+	q := r.URL.Query().Get("ofs")
+	if q == "" {
+		return 0, true
+	}
+
+	ofs, err := strconv.Atoi(q)
+	return ofs, err == nil && ofs >= 0
+}
+
+// diffView is how a diff is shown, from the query of the page.
+type diffView struct {
+	Split bool
+	Opts  git.DiffOptions
+
+	// Parent is the parent of a commit to diff against, counting from
+	// one, or zero for the default diff.
+	Parent int
+}
+
+// maxContext bounds the lines of context a diff can be requested with.
+const maxContext = 1000
+
+// Parse the "view", "ignore-ws", "context" and "parent" query options of a
+// diff.
+func parseDiffView(r *http.Request) (diffView, bool) {
+	q := r.URL.Query()
+	view := diffView{Opts: git.DiffOptions{Context: -1}}
+
+	switch q.Get("view") {
+	case "", "unified":
+	case "split":
+		view.Split = true
+	default:
+		return view, false
+	}
+
+	switch q.Get("ignore-ws") {
+	case "", "0":
+	case "1":
+		view.Opts.IgnoreWhitespace = true
+	default:
+		return view, false
+	}
+
+	if c := q.Get("context"); c != "" {
+		n, err := strconv.Atoi(c)
+		if err != nil || n < 0 || n > maxContext {
+			return view, false
+		}
+		view.Opts.Context = n
+	}
+
+	if p := q.Get("parent"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			return view, false
+		}
+		view.Parent = n
+	}
+
+	return view, true
+}
+
+// Query encodes the view as a query string, empty for the default view.
+func (v diffView) Query() string {
+	q := make(url.Values)
+
+	if v.Split {
+		q.Set("view", "split")
+	}
+
+	if v.Opts.IgnoreWhitespace {
+		q.Set("ignore-ws", "1")
+	}
+
+	if v.Opts.Context >= 0 {
+		q.Set("context", strconv.Itoa(v.Opts.Context))
+	}
+
+	if v.Parent > 0 {
+		q.Set("parent", strconv.Itoa(v.Parent))
+	}
+
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// ToggleSplit switches between the unified and side-by-side views.
+func (v diffView) ToggleSplit() diffView {
+	v.Split = !v.Split
+	return v
+}
+
+// ToggleWhitespace switches whether whitespace changes are ignored.
+func (v diffView) ToggleWhitespace() diffView {
+	v.Opts.IgnoreWhitespace = !v.Opts.IgnoreWhitespace
+	return v
+}
+
+// WithParent switches to the diff against the parent at index i of a
+// commit's parents, or to the default diff if i is negative.
+func (v diffView) WithParent(i int) diffView {
+	v.Parent = i + 1
+	if i < 0 {
+		v.Parent = 0
+	}
+	return v
+}
+
+// lineRange is a range of lines of a file to mark, from the query of the
+// page. The rows of the range are grouped under an id such as "L10-L20" and
+// marked whatever the fragment of the URL, which links to its first line.
+type lineRange struct {
+	Start int
+	End   int
+}
+
+// Parse the "lines" query option, as "start-end" counting from one.
+func parseLineRange(r *http.Request) (lineRange, bool) {
+	var lines lineRange
+
+	s := r.URL.Query().Get("lines")
+	if s == "" {
+		return lines, true
+	}
+
+	i := strings.IndexByte(s, '-')
+	if i == -1 {
+		return lines, false
+	}
+
+	start, err := strconv.Atoi(s[:i])
+	if err != nil || start < 1 {
+		return lines, false
+	}
+
+	end, err := strconv.Atoi(s[i+1:])
+	if err != nil || end < start {
+		return lines, false
+	}
+
+	lines.Start, lines.End = start, end
+	return lines, true
+}
+
+// ID is the id of the rows of the range, empty if there is none.
+func (l lineRange) ID() string {
+	if l.Start == 0 {
+		return ""
+	}
+	return "L" + strconv.Itoa(l.Start) + "-L" + strconv.Itoa(l.End)
+}
+
+// Anchor is the id of the first line of the range, empty if there is none.
+func (l lineRange) Anchor() string {
+	if l.Start == 0 {
+		return ""
+	}
+	return "L" + strconv.Itoa(l.Start)
+}
+
+// Query encodes the range as a query string, empty if there is none.
+func (l lineRange) Query() string {
+	if l.Start == 0 {
+		return ""
+	}
+	return "?lines=" + strconv.Itoa(l.Start) + "-" + strconv.Itoa(l.End)
+}
+
+// section is a run of the numbered lines of a page, From and To being slice
+// indices, with an id if it is the marked range, and its rendered rows.
+type section struct {
+	ID   string
+	From int
+	To   int
+	Rows template.HTML
+}
+
+// Split n lines into the sections before, in and after the range.
+func (l lineRange) sections(n int) []section {
+	if l.Start == 0 || l.Start > n {
+		return []section{{To: n}}
+	}
+
+	end := l.End
+	if end > n {
+		end = n
+	}
+
+	ret := []section{{ID: l.ID(), From: l.Start - 1, To: end}}
+
+	if l.Start > 1 {
+		ret = append([]section{{To: l.Start - 1}}, ret...)
+	}
+
+	if end < n {
+		ret = append(ret, section{From: end, To: n})
+	}
+
+	return ret
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,68 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrTooLarge is used in gitweb to determine if an archive, raw file or object
+// exceeded its size limit.
+var ErrTooLarge = errors.New("git: size limit exceeded")
+
+// Archive streams a snapshot of ref to w in the given git archive format, with
+// every path beginning with prefix. The archive is stopped when it takes
+// longer than timeout or, if limit is positive, grows past limit bytes.
+func (g *Git) Archive(w io.Writer, ref, format, prefix string, limit int64,
+	timeout time.Duration) error {
+	if g.native {
+		return ErrUnsupported
+	}
+
+	if err := g.verify(ref); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := g.command(ctx, "archive", "--format="+format, "--prefix="+prefix,
+		ref)
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	if limit > 0 {
+		var n int64
+		n, err = io.Copy(w, io.LimitReader(out, limit))
+
+		if err == nil && n == limit {
+			var b [1]byte
+			if _, err2 := io.ReadFull(out, b[:]); err2 == nil {
+				err = ErrTooLarge
+			}
+		}
+	} else {
+		_, err = io.Copy(w, out)
+	}
+
+	if err != nil {
+		// kill git rather than wait for it to fill the pipe
+		cancel()
+		_ = cmd.Wait()
+		return err
+	}
+
+	err = cmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+)
+
+// Backends, as named in the configuration
+const (
+	BackendExec   = "exec"
+	BackendNative = "native"
+)
+
+// ErrUnsupported is used in gitweb to determine if the request error was from
+// an operation the backend cannot do, such as diffs without the git binary.
+var ErrUnsupported = errors.New("git: not supported by backend")
+
+// backend reads the refs and objects of a repository.
+type backend interface {
+	// Lookup an object by name, such as "ref:path" or "ref^{commit}", with
+	// its data if contents is set. ErrNotExist if there is no such object.
+	lookup(name string, contents bool) (*object, error)
+
+	// List every ref and the object it points to, sorted by name.
+	refs() ([]refEntry, error)
+
+	// Open the contents of a blob by hash for streaming, which stops when
+	// ctx is done.
+	stream(ctx context.Context, hash string) (io.ReadCloser, error)
+
+	// List the history of a commit hash as git log would, restricted to
+	// path if not empty. Files are followed through renames if possible.
+	log(hash, path string, file bool, skip, count int) ([]*LogItem, error)
+}
+
+// refEntry is a full ref name and the hash it points to.
+type refEntry struct {
+	name string
+	hash string
+}
+
+// execBackend looks up objects through git cat-file processes, and otherwise
+// runs git.
+type execBackend struct {
+	g        *Git
+	check    *batch
+	contents *batch
+}
+
+func newExecBackend(g *Git) *execBackend {
+	return &execBackend{
+		g:        g,
+		check:    &batch{g: g},
+		contents: &batch{g: g, contents: true},
+	}
+}
+
+// batchMax is the largest object read through the shared cat-file --batch
+// process, which answers one lookup at a time.
+const batchMax = 1 << 20
+
+func (b *execBackend) lookup(name string, contents bool) (*object, error) {
+	obj, err := b.check.lookup(name)
+	if err != nil || !contents {
+		return obj, err
+	}
+
+	if b.g.maxSize > 0 && obj.size > b.g.maxSize {
+		return nil, ErrTooLarge
+	}
+
+	if obj.size <= batchMax {
+		return b.contents.lookup(obj.hash)
+	}
+
+	// larger objects are read by their own process, so other lookups
+	// don't wait for them
+	if obj.data, err = b.g.run("cat-file", obj.typ, obj.hash); err != nil {
+		return nil, err
+	}
+
+	if int64(len(obj.data)) != obj.size {
+		return nil, errors.New("git: cat-file: short read")
+	}
+	return obj, nil
+}
+
+// Large blobs are streamed from their own process, so they don't hold up
+// other lookups.
+func (b *execBackend) stream(ctx context.Context, hash string) (io.ReadCloser,
+	error) {
+	cmd := b.g.command(ctx, "cat-file", "blob", hash)
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &cmdReader{ReadCloser: out, cmd: cmd}, nil
+}
+
+// cmdReader is the output of a running command, which is stopped once the
+// output is closed.
+type cmdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *cmdReader) Close() error {
+	_ = r.ReadCloser.Close()
+	_ = r.cmd.Process.Kill()
+	_ = r.cmd.Wait()
+	return nil
+}
+
+func (b *execBackend) refs() ([]refEntry, error) {
+	out, err := b.g.run("for-each-ref", "--format=%(objectname) %(refname)")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out) == 0 {
+		return nil, nil
+	}
+
+	lines := bytes.Split(out[:len(out)-1], []byte{'\n'})
+	ret := make([]refEntry, len(lines))
+
+	for i, line := range lines {
+		fields := bytes.SplitN(line, []byte{' '}, 2)
+		if len(fields) != 2 {
+			return nil, errors.New("git: refs: malformed line")
+		}
+
+		ret[i] = refEntry{name: string(fields[1]), hash: string(fields[0])}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,171 @@
+package git
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// batchIdle is how long an unused batch process is kept running.
+const batchIdle = time.Minute
+
+// batch is a long-running git cat-file --batch or --batch-check process which
+// answers object lookups one at a time. It is started on first use, and
+// stopped after failing, stalling longer than the timeout or idling.
+type batch struct {
+	g        *Git
+	contents bool
+
+	mu   sync.Mutex
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+	out  *bufio.Reader
+	idle *time.Timer
+}
+
+// Lookup an object by name, such as "ref:path" or "ref^{commit}".
+func (b *batch) lookup(name string) (*object, error) {
+	// names are newline-terminated
+	if strings.ContainsAny(name, "\n") {
+		return nil, ErrNotExist
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cmd == nil {
+		if err := b.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	var expired int32
+	cmd := b.cmd
+	t := time.AfterFunc(b.g.timeout, func() {
+		atomic.StoreInt32(&expired, 1)
+		_ = cmd.Process.Kill()
+	})
+
+	obj, err := b.read(name)
+	t.Stop()
+
+	if err != nil {
+		b.stop()
+		if atomic.LoadInt32(&expired) == 1 {
+			err = context.DeadlineExceeded
+		}
+		return nil, err
+	}
+
+	b.idle.Reset(batchIdle)
+
+	if obj == nil {
+		return nil, ErrNotExist
+	}
+	return obj, nil
+}
+
+// Utility: request an object and read the response, nil if missing
+func (b *batch) read(name string) (*object, error) {
+	if _, err := io.WriteString(b.in, name+"\n"); err != nil {
+		return nil, err
+	}
+
+	line, err := b.out.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+
+	// the name is echoed back for missing objects, and may contain spaces
+	if strings.HasSuffix(line, " missing") ||
+		strings.HasSuffix(line, " ambiguous") {
+		return nil, nil
+	}
+
+	// fields[0] = hash
+	// fields[1] = type
+	// fields[2] = size
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil, errors.New("git: cat-file: malformed header")
+	}
+
+	obj := &object{
+		hash: fields[0],
+		typ:  fields[1],
+	}
+
+	obj.size, err = strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.size < 0 {
+		return nil, errors.New("git: cat-file: malformed size")
+	}
+
+	if !b.contents {
+		return obj, nil
+	}
+
+	// contents are followed by a newline
+	obj.data = make([]byte, obj.size+1)
+	if _, err = io.ReadFull(b.out, obj.data); err != nil {
+		return nil, err
+	}
+	obj.data = obj.data[:obj.size]
+
+	return obj, nil
+}
+
+// Utility: start the cat-file process
+func (b *batch) start() error {
+	arg := "--batch-check"
+	if b.contents {
+		arg = "--batch"
+	}
+
+	cmd := b.g.command(context.Background(), "cat-file", arg)
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	b.cmd, b.in, b.out = cmd, in, bufio.NewReader(out)
+	b.idle = time.AfterFunc(batchIdle, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.cmd == cmd {
+			b.stop()
+		}
+	})
+	return nil
+}
+
+// Utility: stop the cat-file process
+func (b *batch) stop() {
+	b.idle.Stop()
+	_ = b.in.Close()
+	_ = b.cmd.Process.Kill()
+	_ = b.cmd.Wait()
+	b.cmd, b.in, b.out = nil, nil, nil
+}
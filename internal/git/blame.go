@@ -0,0 +1,152 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// BlameGroup is a run of consecutive lines last changed by the same commit.
+type BlameGroup struct {
+	Hash    string
+	Author  string
+	Time    time.Time
+	Summary string
+	Line    int
+	Lines   []string
+}
+
+// Blame contains the line groups of a file, or marks it as binary.
+type Blame struct {
+	Binary bool
+	Groups []*BlameGroup
+}
+
+// Blame retrieves which commit last changed each line of a tracked file at
+// ref.
+func (g *Git) Blame(ref, file string) (blame Blame, err error) {
+	if err = g.verify(ref); err != nil {
+		return
+	}
+
+	obj, err := g.blob(ref, file)
+	if err != nil {
+		return
+	}
+
+	if blame.Binary = isBinary(obj.data); blame.Binary {
+		return
+	}
+
+	out, err := g.run("blame", "--porcelain", ref, "--", file)
+	if err != nil {
+		return
+	}
+
+	blame.Groups, err = parseBlame(out)
+	return
+}
+
+func parseBlame(out []byte) ([]*BlameGroup, error) {
+	// Porcelain output is a header line "hash orig-line final-line [count]"
+	// for every source line, where the count begins a new group. The first
+	// time a commit appears its header is followed by "key value" lines.
+	// The source line itself follows, prefixed with a tab.
+	var groups []*BlameGroup
+	var group *BlameGroup
+	commits := make(map[string]*BlameGroup)
+	header := true
+
+	for len(out) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(out, '\n'); i == -1 {
+			line, out = out, nil
+		} else {
+			line, out = out[:i], out[i+1:]
+		}
+
+		if len(line) > 0 && line[0] == '\t' {
+			if group == nil {
+				return nil, errors.New("git: blame: line before header")
+			}
+			group.Lines = append(group.Lines, string(line[1:]))
+			header = true
+			continue
+		}
+
+		if !header {
+			key, value := line, []byte(nil)
+			if i := bytes.IndexByte(line, ' '); i != -1 {
+				key, value = line[:i], line[i+1:]
+			}
+
+			if err := parseBlameInfo(group, key, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// fields[0] = hash
+		// fields[1] = original line number
+		// fields[2] = final line number
+		// fields[3] = number of lines in group, if starting a group
+		fields := bytes.Fields(line)
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, errors.New("git: blame: malformed header")
+		}
+
+		header = false
+		hash := string(fields[0])
+
+		if len(fields) == 3 {
+			if group == nil || group.Hash != hash {
+				return nil, errors.New("git: blame: header outside group")
+			}
+			continue
+		}
+
+		n, err := strconv.Atoi(string(fields[2]))
+		if err != nil {
+			return nil, err
+		}
+
+		group = &BlameGroup{
+			Hash: hash,
+			Line: n,
+		}
+
+		if c, ok := commits[hash]; ok {
+			group.Author = c.Author
+			group.Time = c.Time
+			group.Summary = c.Summary
+		} else {
+			commits[hash] = group
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+func parseBlameInfo(group *BlameGroup, key, value []byte) error {
+	if group == nil {
+		return errors.New("git: blame: info before header")
+	}
+
+	switch string(key) {
+	case "author":
+		group.Author = string(value)
+	case "author-time":
+		t, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return err
+		}
+		group.Time = time.Unix(t, 0)
+	case "summary":
+		group.Summary = string(value)
+	}
+
+	return nil
+}
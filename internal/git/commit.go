@@ -3,63 +3,176 @@ package git
 import (
 	"errors"
 	"regexp"
+	"strconv"
+	"strings"
 )
 
-// Commit contains details about a commit.
+// Commit contains details about a commit. The message is split into the
+// subject, the body and any trailers in its last paragraph. NoDiff is set
+// instead of Diff when the backend can't generate diffs.
 type Commit struct {
-	CatFile  []byte
-	DiffStat []byte
-	Diff     []byte
+	Hash      string
+	Tree      string
+	Parents   []string
+	Author    Signature
+	Committer Signature
+	Subject   string
+	Body      string
+	Trailers  []Trailer
+	Diff      Diff
+	NoDiff    bool
+}
+
+// Trailer is a "Key: value" line at the end of a commit message, such as
+// Signed-off-by.
+type Trailer struct {
+	Key   string
+	Value string
 }
 
 // ErrInvalidHash is used in gitweb to determine if the request error was from a
 // bad request or happened running git.
 var ErrInvalidHash = errors.New("git: commit: not a hash")
 
-var reNotHash = regexp.MustCompile("[^0-9A-Za-z]")
+// emptyTree is the hash of the tree root commits are diffed against, by the
+// length of the hashes of the repository.
+var emptyTree = map[int]string{
+	40: "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+	64: "6ef19b41225c5369f1c104d45d8d85efa9b057b53b14b4b9b939dd74decc5321",
+}
 
-// Commit retrieves details about a commit.
-func (g *Git) Commit(hash string) (*Commit, error) {
-	if len(hash) != 40 || reNotHash.MatchString(hash) {
+// DiffOptions change how diffs are generated.
+type DiffOptions struct {
+	// IgnoreWhitespace ignores changes in whitespace when comparing lines.
+	IgnoreWhitespace bool
+
+	// Context is the number of lines of context around changes, or git's
+	// default if negative.
+	Context int
+}
+
+// ErrNoParent is used in gitweb to determine if the request error was from
+// asking for the diff against a parent the commit doesn't have.
+var ErrNoParent = errors.New("git: commit: no such parent")
+
+// Commit retrieves details about the commit with a full hash in the object
+// format of the repository, with its diff generated using opts. Other names must be resolved
+// first. The diff is against the parent numbered from one, or if parent is
+// zero, the combined diff against all parents of a merge and otherwise the
+// diff against the only parent or the empty tree.
+func (g *Git) Commit(hash string, parent int,
+	opts DiffOptions) (*Commit, error) {
+	if !g.IsHash(hash) {
 		return nil, ErrInvalidHash
 	}
 
-	errs := make(chan error, 3)
-	defer close(errs)
+	obj, err := g.db.lookup(hash, true)
+	if err == ErrNotExist || err == nil && obj.typ != "commit" {
+		return nil, ErrInvalidRef
+	} else if err != nil {
+		return nil, err
+	}
 
-	commit := &Commit{}
+	c, err := parseCommit(obj.data)
+	if err != nil {
+		return nil, err
+	}
 
-	go func() {
-		var err error
-		commit.CatFile, err = g.run("cat-file", "-p", hash)
-		errs <- err
-	}()
+	commit := &Commit{
+		Hash:      obj.hash,
+		Tree:      c.tree,
+		Parents:   c.parents,
+		Author:    c.author,
+		Committer: c.committer,
+		Subject:   c.subject(),
+	}
 
-	// empty tree commit hash
-	with := "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	commit.Body, commit.Trailers = parseMessage(c.message)
 
-	if g.hasParents(hash) {
-		with = hash + "~"
+	if parent < 0 || parent > len(c.parents) {
+		return nil, ErrNoParent
 	}
 
-	go func() {
-		var err error
-		commit.DiffStat, err = g.run("diff", "--stat", with, hash)
-		errs <- err
-	}()
-
-	go func() {
-		var err error
-		commit.Diff, err = g.run("diff", with, hash)
-		errs <- err
-	}()
-
-	var err error
-	for i := 0; i < cap(errs); i++ {
-		if err2 := <-errs; err == nil {
-			err = err2
+	var out []byte
+
+	if parent == 0 && len(c.parents) > 1 {
+		// only files which differ from every parent are shown
+		arg := []string{"diff-tree", "--no-commit-id", "-p", "--cc"}
+		out, err = g.run(append(append(arg, opts.args()...), hash)...)
+	} else {
+		with := emptyTree[g.hashLen]
+
+		if parent > 0 {
+			with = c.parents[parent-1]
+		} else if len(c.parents) > 0 {
+			with = c.parents[0]
 		}
+
+		arg := append([]string{"diff"}, opts.args()...)
+		out, err = g.run(append(arg, with, hash)...)
+	}
+	if err == ErrUnsupported {
+		commit.NoDiff = true
+		return commit, nil
+	} else if err != nil {
+		return nil, err
 	}
 
+	commit.Diff, err = ParseDiff(out)
 	return commit, err
 }
+
+var reTrailer = regexp.MustCompile(`^([A-Za-z0-9-]+):\s*(.*)$`)
+
+// Utility: split a commit message into the body after the subject and the
+// trailers of its last paragraph
+func parseMessage(msg []byte) (string, []Trailer) {
+	paragraphs := strings.Split(strings.TrimSpace(string(msg)), "\n\n")
+	if len(paragraphs) < 2 {
+		return "", nil
+	}
+
+	paragraphs = paragraphs[1:]
+	last := strings.Split(paragraphs[len(paragraphs)-1], "\n")
+
+	var trailers []Trailer
+
+	for _, line := range last {
+		// values may be folded onto indented lines
+		if len(trailers) > 0 && (strings.HasPrefix(line, " ") ||
+			strings.HasPrefix(line, "\t")) {
+			t := &trailers[len(trailers)-1]
+			t.Value += " " + strings.TrimSpace(line)
+			continue
+		}
+
+		m := reTrailer.FindStringSubmatch(line)
+		if m == nil {
+			trailers = nil
+			break
+		}
+
+		trailers = append(trailers, Trailer{Key: m[1], Value: m[2]})
+	}
+
+	if trailers != nil {
+		paragraphs = paragraphs[:len(paragraphs)-1]
+	}
+
+	return strings.Join(paragraphs, "\n\n"), trailers
+}
+
+// Utility: the git diff arguments for the options
+func (opts DiffOptions) args() []string {
+	arg := []string{"--no-ext-diff", "--no-textconv", "-M", "-C"}
+
+	if opts.IgnoreWhitespace {
+		arg = append(arg, "--ignore-all-space")
+	}
+
+	if opts.Context >= 0 {
+		arg = append(arg, "--unified="+strconv.Itoa(opts.Context))
+	}
+
+	return arg
+}
@@ -0,0 +1,95 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strconv"
+)
+
+// Comparison contains the changes on head since it diverged from base.
+type Comparison struct {
+	Base      string
+	Head      string
+	MergeBase string
+	Ahead     int
+	Behind    int
+	Log       []*LogItem
+	Diff      Diff
+}
+
+// ErrUnrelated is used in gitweb to determine if the request error was from
+// comparing refs without a common ancestor.
+var ErrUnrelated = errors.New("git: refs have no common ancestor")
+
+// Compare retrieves the commits on head which are not on base, at most count
+// if count is positive, and the diff from their merge base to head generated
+// using opts. Ahead and behind count the commits only on head and only on
+// base.
+func (g *Git) Compare(base, head string, count int,
+	opts DiffOptions) (*Comparison, error) {
+	var err error
+	cmp := &Comparison{}
+
+	if cmp.Base, err = g.Resolve(base); err != nil {
+		return nil, err
+	}
+
+	if cmp.Head, err = g.Resolve(head); err != nil {
+		return nil, err
+	}
+
+	out, err := g.run("merge-base", cmp.Base, cmp.Head)
+	if err != nil {
+		// git exits with 1 if there is no merge base
+		if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 {
+			err = ErrUnrelated
+		}
+		return nil, err
+	}
+
+	cmp.MergeBase = string(bytes.TrimSpace(out))
+
+	// "behind ahead", counting the left and right sides
+	out, err = g.run("rev-list", "--left-right", "--count",
+		cmp.Base+"..."+cmp.Head)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := bytes.Fields(out)
+	if len(counts) != 2 {
+		return nil, errors.New("git: compare: malformed counts")
+	}
+
+	if cmp.Behind, err = strconv.Atoi(string(counts[0])); err != nil {
+		return nil, err
+	}
+
+	if cmp.Ahead, err = strconv.Atoi(string(counts[1])); err != nil {
+		return nil, err
+	}
+
+	arg := []string{"log", logFormat, "--shortstat"}
+	if count > 0 {
+		arg = append(arg, "--max-count="+strconv.Itoa(count))
+	}
+
+	out, err = g.run(append(arg, cmp.Base+".."+cmp.Head, "--")...)
+	if err != nil {
+		return nil, err
+	}
+
+	if cmp.Log, err = parseLog(out); err != nil {
+		return nil, err
+	}
+
+	arg = append([]string{"diff"}, opts.args()...)
+	out, err = g.run(append(arg, cmp.MergeBase, cmp.Head)...)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp.Diff, err = ParseDiff(out)
+	return cmp, err
+}
@@ -0,0 +1,191 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Config retrieves the repository's git config variables in section as
+// key-value pairs, in the order git lists them. Keys are lowercase and
+// include the section name. Without git only the repository's own config file
+// is read.
+func (g *Git) Config(section string) ([][2]string, error) {
+	out, err := g.run("config", "--null", "--get-regexp",
+		"^"+regexp.QuoteMeta(section)+`\.`)
+	if err != nil {
+		// git exits with 1 if no variables matched
+		if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 {
+			return nil, nil
+		}
+
+		if e, ok := err.(*exec.Error); err == ErrUnsupported ||
+			ok && e.Err == exec.ErrNotFound {
+			dir, err := gitDir(g.path)
+			if err != nil {
+				return nil, err
+			}
+			return readConfig(dir, section)
+		}
+
+		return nil, err
+	}
+
+	if len(out) == 0 {
+		return nil, nil
+	}
+
+	// each variable is "key\nvalue\x00"
+	vars := bytes.Split(out[:len(out)-1], []byte{0})
+	ret := make([][2]string, len(vars))
+
+	for i, v := range vars {
+		kv := bytes.SplitN(v, []byte{'\n'}, 2)
+		if len(kv) != 2 {
+			return nil, errors.New("git: config: missing value")
+		}
+
+		ret[i] = [2]string{string(kv[0]), string(kv[1])}
+	}
+
+	return ret, nil
+}
+
+var errConfigSyntax = errors.New("git: config: bad syntax")
+
+// Utility: read the variables in section from the config file of the git
+// directory, without following includes
+func readConfig(dir, section string) ([][2]string, error) {
+	f, err := os.Open(filepath.Join(dir, "config"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	section = strings.ToLower(section)
+
+	var ret [][2]string
+	var name string
+	s := bufio.NewScanner(f)
+
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+
+		// values continue onto the next line after a backslash
+		for strings.HasSuffix(line, `\`) &&
+			!strings.HasSuffix(line, `\\`) && s.Scan() {
+			line = line[:len(line)-1] + s.Text()
+		}
+
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if name, line, err = configSection(line); err != nil {
+				return nil, err
+			}
+			if line == "" {
+				continue
+			}
+		}
+
+		key, value := line, "true"
+		if i := strings.IndexByte(line, '='); i != -1 {
+			key = strings.TrimSpace(line[:i])
+			if value, err = configValue(line[i+1:]); err != nil {
+				return nil, err
+			}
+		} else if i := strings.IndexAny(line, "#;"); i != -1 {
+			key = strings.TrimSpace(line[:i])
+		}
+
+		if name == section || strings.HasPrefix(name, section+".") {
+			ret = append(ret, [2]string{name + "." + strings.ToLower(key),
+				value})
+		}
+	}
+
+	return ret, s.Err()
+}
+
+// Utility: parse a section header, such as [core] or [remote "origin"], into
+// its dotted name and the rest of the line
+func configSection(line string) (string, string, error) {
+	end := strings.IndexByte(line, ']')
+	if end == -1 {
+		return "", "", errConfigSyntax
+	}
+
+	header, rest := line[1:end], strings.TrimSpace(line[end+1:])
+
+	// subsections are case sensitive, section names are not
+	if i := strings.IndexByte(header, '"'); i != -1 {
+		sub := strings.TrimSuffix(header[i+1:], `"`)
+		sub = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(sub)
+		header = strings.ToLower(strings.TrimSpace(header[:i])) + "." +
+			sub
+	} else {
+		header = strings.ToLower(strings.TrimSpace(header))
+	}
+
+	return header, rest, nil
+}
+
+// Utility: unquote a value, dropping any comment after it
+func configValue(s string) (string, error) {
+	var b strings.Builder
+	var quoted bool
+
+	// whitespace up to keep was quoted or escaped
+	var keep int
+
+	s = strings.TrimSpace(s)
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			quoted = !quoted
+			keep = b.Len()
+		case c == '\\':
+			if i++; i == len(s) {
+				return "", errConfigSyntax
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			case '"', '\\':
+				b.WriteByte(s[i])
+			default:
+				return "", errConfigSyntax
+			}
+			keep = b.Len()
+		case (c == '#' || c == ';') && !quoted:
+			i = len(s)
+		default:
+			b.WriteByte(c)
+			if quoted {
+				keep = b.Len()
+			}
+		}
+	}
+
+	if quoted {
+		return "", errConfigSyntax
+	}
+
+	v := b.String()
+	return v[:keep] + strings.TrimRight(v[keep:], " \t"), nil
+}
@@ -0,0 +1,530 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Diff line types
+const (
+	DiffContext = iota
+	DiffAdd
+	DiffDelete
+	DiffNoNewline
+)
+
+// DiffLine is a line of a hunk. Line numbers are zero if the line is not in
+// that side of the diff. In a combined diff Marks has a column for each
+// parent, and only the new line numbers are known.
+type DiffLine struct {
+	Type    int
+	OldLine int
+	NewLine int
+	Marks   string
+	Text    string
+}
+
+// IsAdd reports whether the line was added.
+func (line *DiffLine) IsAdd() bool {
+	return line.Type == DiffAdd
+}
+
+// IsDelete reports whether the line was deleted.
+func (line *DiffLine) IsDelete() bool {
+	return line.Type == DiffDelete
+}
+
+// IsNoNewline reports whether the line marks the previous line as missing a
+// trailing newline.
+func (line *DiffLine) IsNoNewline() bool {
+	return line.Type == DiffNoNewline
+}
+
+// DiffHunk is a run of changed lines with their context.
+type DiffHunk struct {
+	Header   string
+	OldStart int
+	NewStart int
+	Lines    []*DiffLine
+}
+
+// DiffRow is a row of a side-by-side diff. A side is nil if the line on the
+// other side has no counterpart.
+type DiffRow struct {
+	Old *DiffLine
+	New *DiffLine
+}
+
+// Rows pairs the lines of the hunk for side-by-side display. Context lines
+// are on both sides, and each run of deleted lines is aligned with the added
+// lines which follow it.
+func (h *DiffHunk) Rows() []DiffRow {
+	var rows []DiffRow
+	var old, new []*DiffLine
+	var prev *DiffLine
+
+	flush := func() {
+		for i := 0; i < len(old) || i < len(new); i++ {
+			var row DiffRow
+			if i < len(old) {
+				row.Old = old[i]
+			}
+			if i < len(new) {
+				row.New = new[i]
+			}
+			rows = append(rows, row)
+		}
+		old, new = nil, nil
+	}
+
+	for _, line := range h.Lines {
+		typ := line.Type
+
+		// the marker belongs to the side of the line before it
+		if typ == DiffNoNewline && prev != nil {
+			typ = prev.Type
+		}
+
+		switch typ {
+		case DiffDelete:
+			// deletions after additions start a new run
+			if len(new) > 0 {
+				flush()
+			}
+			old = append(old, line)
+		case DiffAdd:
+			new = append(new, line)
+		default:
+			flush()
+			rows = append(rows, DiffRow{Old: line, New: line})
+		}
+
+		if line.Type != DiffNoNewline {
+			prev = line
+		}
+	}
+
+	flush()
+	return rows
+}
+
+// Diff file statuses
+const (
+	DiffModified = iota
+	DiffAdded
+	DiffDeleted
+	DiffRenamed
+	DiffCopied
+)
+
+// DiffFile is the diff of a single file. Names are empty for the missing side
+// of added and deleted files, and modes are empty if unknown. Combined diffs
+// of merges compare the file with every parent at once, and the old mode may
+// list the mode in each parent separated by commas.
+type DiffFile struct {
+	Combined   bool
+	Status     int
+	OldName    string
+	NewName    string
+	OldMode    string
+	NewMode    string
+	Similarity int
+	Binary     bool
+	Hunks      []*DiffHunk
+	Insertions uint64
+	Deletions  uint64
+}
+
+// Name is the name of the file after the change, or before it if deleted.
+func (f *DiffFile) Name() string {
+	if f.Status == DiffDeleted {
+		return f.OldName
+	}
+	return f.NewName
+}
+
+// IsAdded reports whether the file was added.
+func (f *DiffFile) IsAdded() bool {
+	return f.Status == DiffAdded
+}
+
+// IsDeleted reports whether the file was deleted.
+func (f *DiffFile) IsDeleted() bool {
+	return f.Status == DiffDeleted
+}
+
+// IsRenamed reports whether the file was renamed from OldName.
+func (f *DiffFile) IsRenamed() bool {
+	return f.Status == DiffRenamed
+}
+
+// IsCopied reports whether the file was copied from OldName.
+func (f *DiffFile) IsCopied() bool {
+	return f.Status == DiffCopied
+}
+
+// ModeChanged reports whether the file mode changed, such as becoming
+// executable.
+func (f *DiffFile) ModeChanged() bool {
+	if f.OldMode == "" || f.NewMode == "" {
+		return false
+	}
+
+	for _, mode := range strings.Split(f.OldMode, ",") {
+		if mode != f.NewMode {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff is a parsed unified diff, one entry per changed file.
+type Diff []*DiffFile
+
+// Stat sums the changed files and lines of the diff.
+func (d Diff) Stat() (stat LogStat) {
+	stat.Changed = uint64(len(d))
+	for _, f := range d {
+		stat.Insertions += f.Insertions
+		stat.Deletions += f.Deletions
+	}
+	return
+}
+
+var errMalformedDiff = errors.New("git: diff: malformed")
+
+// ParseDiff parses the output of git diff, including the combined diffs of
+// merges.
+func ParseDiff(raw []byte) (Diff, error) {
+	var diff Diff
+	var file *DiffFile
+	var hunk *DiffHunk
+	var oldLine, newLine int
+
+	// the width of the line prefix, one column per parent
+	var cols int
+
+	for len(raw) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(raw, '\n'); i == -1 {
+			line, raw = raw, nil
+		} else {
+			line, raw = raw[:i], raw[i+1:]
+		}
+
+		if bytes.HasPrefix(line, []byte("diff --git ")) {
+			file = &DiffFile{}
+			hunk = nil
+			diff = append(diff, file)
+
+			if err := parseDiffGit(file, string(line)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if bytes.HasPrefix(line, []byte("diff --cc ")) ||
+			bytes.HasPrefix(line, []byte("diff --combined ")) {
+			file = &DiffFile{Combined: true}
+			hunk = nil
+			diff = append(diff, file)
+
+			if err := parseDiffCombined(file, string(line)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if file == nil {
+			return nil, errMalformedDiff
+		}
+
+		if hunk != nil && file.Combined {
+			l := parseCombinedLine(file, string(line), cols, &newLine)
+			if l != nil {
+				hunk.Lines = append(hunk.Lines, l)
+				continue
+			}
+		} else if hunk != nil {
+			// context lines are never empty, but tolerate lost spaces
+			if len(line) == 0 {
+				line = []byte{' '}
+			}
+
+			l := &DiffLine{Text: string(line[1:])}
+
+			switch line[0] {
+			case ' ':
+				l.Type = DiffContext
+				l.OldLine, l.NewLine = oldLine, newLine
+				oldLine++
+				newLine++
+			case '+':
+				l.Type = DiffAdd
+				l.NewLine = newLine
+				newLine++
+				file.Insertions++
+			case '-':
+				l.Type = DiffDelete
+				l.OldLine = oldLine
+				oldLine++
+				file.Deletions++
+			case '\\':
+				l.Type = DiffNoNewline
+			default:
+				l = nil
+			}
+
+			if l != nil {
+				hunk.Lines = append(hunk.Lines, l)
+				continue
+			}
+		}
+
+		if bytes.HasPrefix(line, []byte("@@")) {
+			hunk = &DiffHunk{Header: string(line)}
+			if err := parseHunkHeader(hunk); err != nil {
+				return nil, err
+			}
+
+			// "@@@" for two parents
+			cols = bytes.IndexByte(line, ' ') - 1
+
+			oldLine, newLine = hunk.OldStart, hunk.NewStart
+			file.Hunks = append(file.Hunks, hunk)
+			continue
+		}
+
+		if hunk != nil {
+			return nil, errMalformedDiff
+		}
+
+		if err := parseDiffHeader(file, string(line)); err != nil {
+			return nil, err
+		}
+	}
+
+	return diff, nil
+}
+
+// Utility: take the names from "diff --git a/old b/new", which are only
+// unambiguous when they are the same or quoted, and otherwise are replaced by
+// the later headers
+func parseDiffGit(file *DiffFile, line string) error {
+	names := strings.TrimPrefix(line, "diff --git ")
+
+	if strings.HasPrefix(names, `"`) {
+		old, rest, err := unquotePath(names)
+		if err != nil {
+			return err
+		}
+
+		file.OldName = strings.TrimPrefix(old, "a/")
+		names = strings.TrimPrefix(rest, " ")
+	} else {
+		// the names are equal, split in the middle
+		n := len(names)
+		if n%2 == 0 || names[n/2] != ' ' {
+			return nil
+		}
+
+		file.OldName = strings.TrimPrefix(names[:n/2], "a/")
+		names = names[n/2+1:]
+	}
+
+	if strings.HasPrefix(names, `"`) {
+		name, _, err := unquotePath(names)
+		if err != nil {
+			return err
+		}
+		names = name
+	}
+
+	file.NewName = strings.TrimPrefix(names, "b/")
+	return nil
+}
+
+// Utility: take the name from "diff --cc name", which is the same on all
+// sides
+func parseDiffCombined(file *DiffFile, line string) error {
+	name := strings.TrimPrefix(strings.TrimPrefix(line, "diff --cc "),
+		"diff --combined ")
+
+	if strings.HasPrefix(name, `"`) {
+		var err error
+		if name, _, err = unquotePath(name); err != nil {
+			return err
+		}
+	}
+
+	file.OldName, file.NewName = name, name
+	return nil
+}
+
+// Utility: parse a line of a combined hunk, which begins with a column for
+// each parent marking whether the line was added or removed relative to it,
+// returning nil if it isn't part of the hunk
+func parseCombinedLine(file *DiffFile, line string, cols int,
+	newLine *int) *DiffLine {
+	if strings.HasPrefix(line, "\\") {
+		return &DiffLine{Type: DiffNoNewline, Text: line[1:]}
+	}
+
+	// tolerate lost spaces on empty context lines
+	if len(line) < cols && strings.TrimLeft(line, " ") == "" {
+		line += strings.Repeat(" ", cols-len(line))
+	}
+
+	if len(line) < cols || strings.Trim(line[:cols], " +-") != "" {
+		return nil
+	}
+
+	l := &DiffLine{Marks: line[:cols], Text: line[cols:]}
+
+	switch {
+	case strings.Contains(l.Marks, "-"):
+		// removed lines are only in the parents
+		l.Type = DiffDelete
+		file.Deletions++
+	case strings.Contains(l.Marks, "+"):
+		l.Type = DiffAdd
+		l.NewLine = *newLine
+		*newLine++
+		file.Insertions++
+	default:
+		l.Type = DiffContext
+		l.NewLine = *newLine
+		*newLine++
+	}
+
+	return l
+}
+
+// Utility: parse an extended header line of a file diff
+func parseDiffHeader(file *DiffFile, line string) error {
+	var err error
+
+	header := func(prefix string) (string, bool) {
+		if !strings.HasPrefix(line, prefix) {
+			return "", false
+		}
+		return line[len(prefix):], true
+	}
+
+	path := func(s string) string {
+		// names with spaces are followed by a tab
+		s = strings.TrimSuffix(s, "\t")
+
+		if strings.HasPrefix(s, `"`) {
+			var p string
+			if p, _, err = unquotePath(s); err == nil {
+				s = p
+			}
+		}
+		return s
+	}
+
+	if v, ok := header("mode "); ok {
+		// "mode old,old..new" in combined diffs
+		if i := strings.Index(v, ".."); i != -1 {
+			file.OldMode, file.NewMode = v[:i], v[i+2:]
+		}
+	} else if v, ok := header("old mode "); ok {
+		file.OldMode = v
+	} else if v, ok := header("new mode "); ok {
+		file.NewMode = v
+	} else if v, ok := header("new file mode "); ok {
+		file.Status = DiffAdded
+		file.NewMode = v
+	} else if v, ok := header("deleted file mode "); ok {
+		file.Status = DiffDeleted
+		file.OldMode = v
+	} else if v, ok := header("similarity index "); ok {
+		file.Similarity, err = strconv.Atoi(strings.TrimSuffix(v, "%"))
+	} else if v, ok := header("rename from "); ok {
+		file.Status = DiffRenamed
+		file.OldName = path(v)
+	} else if v, ok := header("rename to "); ok {
+		file.NewName = path(v)
+	} else if v, ok := header("copy from "); ok {
+		file.Status = DiffCopied
+		file.OldName = path(v)
+	} else if v, ok := header("copy to "); ok {
+		file.NewName = path(v)
+	} else if v, ok := header("index "); ok {
+		// "index old..new mode" has the mode if unchanged
+		if i := strings.IndexByte(v, ' '); i != -1 && file.OldMode == "" &&
+			file.NewMode == "" {
+			file.OldMode, file.NewMode = v[i+1:], v[i+1:]
+		}
+	} else if v, ok := header("--- "); ok {
+		if v = path(v); v != "/dev/null" {
+			file.OldName = strings.TrimPrefix(v, "a/")
+		}
+	} else if v, ok := header("+++ "); ok {
+		if v = path(v); v != "/dev/null" {
+			file.NewName = strings.TrimPrefix(v, "b/")
+		}
+	} else if strings.HasPrefix(line, "Binary files ") {
+		file.Binary = true
+	}
+
+	if file.Status == DiffAdded {
+		file.OldName = ""
+	} else if file.Status == DiffDeleted {
+		file.NewName = ""
+	}
+
+	return err
+}
+
+// Utility: parse "@@ -old,count +new,count @@ section", which has an old
+// range for each parent and an @ sign per range in combined diffs
+func parseHunkHeader(hunk *DiffHunk) error {
+	fields := strings.Fields(hunk.Header)
+
+	// the new range follows the old ranges
+	n := len(fields[0])
+	if n < 2 || strings.Trim(fields[0], "@") != "" || len(fields) < n+2 ||
+		fields[n+1] != fields[0] {
+		return errMalformedDiff
+	}
+
+	start := func(s, sign string) (int, error) {
+		if !strings.HasPrefix(s, sign) {
+			return 0, errMalformedDiff
+		}
+
+		s = s[1:]
+		if i := strings.IndexByte(s, ','); i != -1 {
+			s = s[:i]
+		}
+		return strconv.Atoi(s)
+	}
+
+	var err error
+
+	if hunk.OldStart, err = start(fields[1], "-"); err != nil {
+		return err
+	}
+
+	hunk.NewStart, err = start(fields[n], "+")
+	return err
+}
+
+// Utility: unquote a C-style quoted path at the start of s, as git quotes
+// unusual names, returning the rest of s
+func unquotePath(s string) (string, string, error) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			path, err := strconv.Unquote(s[:i+1])
+			return path, s[i+1:], err
+		}
+	}
+	return "", "", errMalformedDiff
+}
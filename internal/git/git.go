@@ -3,61 +3,184 @@ package git
 import (
 	"bytes"
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"os/exec"
+	"strings"
 	"time"
 )
 
-// Git is a wrapper for restricted git commands useful to gitweb.
+// Git is a wrapper for restricted git commands useful to gitweb. Refs and
+// objects are read through a backend, which either runs git or reads the
+// repository itself.
 type Git struct {
 	path    string
 	ref     string
 	timeout time.Duration
+	maxSize int64
+	native  bool
+
+	// the length of full hashes in hex, by the object format
+	hashLen int
+
+	db backend
 }
 
-// NewGit creates and initializes a new Git.
-func NewGit(path, ref string, timeout time.Duration) *Git {
-	return &Git{
+// NewGit creates and initializes a new Git using the named backend. Objects
+// larger than maxSize bytes are not read into memory.
+func NewGit(path, ref, backend string, timeout time.Duration,
+	maxSize int64) (*Git, error) {
+	g := &Git{
 		path:    path,
 		ref:     ref,
 		timeout: timeout,
+		maxSize: maxSize,
+		hashLen: 40,
+	}
+
+	dir, err := gitDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format, err := objectFormat(dir); {
+	case err != nil:
+		return nil, err
+	case format == "sha256":
+		g.hashLen = 64
+	case format != "" && format != "sha1":
+		return nil, errors.New("git: unknown object format " + format)
 	}
+
+	switch backend {
+	case BackendExec:
+		g.db = newExecBackend(g)
+	case BackendNative:
+		db, err := openNative(path, timeout, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		g.native, g.db = true, db
+	default:
+		return nil, errors.New("git: unknown backend " + backend)
+	}
+
+	return g, nil
 }
 
-// Ref retrieves the current repository reference.
+// Ref retrieves the default repository reference.
 func (g *Git) Ref() string {
 	return g.ref
 }
 
-// Utility: check if file is "binary" or printable as plain-text
-func (g *Git) binary(file string) bool {
-	out, err := g.run("grep", "-I", "--name-only", "-e", ".", "--", file)
-	return err != nil || len(out) == 0
+// IsHash reports whether name is a full hash in the object format of the
+// repository, rather than a name which may move.
+func (g *Git) IsHash(name string) bool {
+	return len(name) == g.hashLen && strings.ToLower(name) == name &&
+		isHex(name)
+}
+
+// ErrInvalidRef is used in gitweb to determine if the request error was from a
+// bad request or happened running git.
+var ErrInvalidRef = errors.New("git: not a valid ref")
+
+// Resolve retrieves the full hash of the commit ref names. Names which search
+// the history or read the reflog, such as ":/text" and "main@{1}", are
+// rejected.
+func (g *Git) Resolve(ref string) (string, error) {
+	if ref == "" || ref[0] == '-' || ref[0] == ':' ||
+		strings.Contains(ref, "@{") || strings.Contains(ref, "{/") {
+		return "", ErrInvalidRef
+	}
+
+	obj, err := g.db.lookup(ref+"^{commit}", false)
+	switch err {
+	case nil:
+		return obj.hash, nil
+	case ErrNotExist:
+		return "", ErrInvalidRef
+	default:
+		return "", err
+	}
+}
+
+// RefsState retrieves a digest of every ref and what it points to, which
+// changes whenever a ref is created, moved or deleted.
+func (g *Git) RefsState() (string, error) {
+	refs, err := g.db.refs()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.New()
+	for _, ref := range refs {
+		fmt.Fprintf(h, "%s %s\n", ref.hash, ref.name)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Utility: check if ref names a commit
+func (g *Git) verify(ref string) error {
+	_, err := g.Resolve(ref)
+	return err
+}
+
+// Utility: check if contents are "binary" or printable as plain-text, using
+// the same heuristic as git
+func isBinary(b []byte) bool {
+	const firstFewBytes = 8000
+	if len(b) > firstFewBytes {
+		b = b[:firstFewBytes]
+	}
+	return bytes.IndexByte(b, 0) != -1
 }
 
 // Utility: check if file exists according to git
-func (g *Git) exists(file string) bool {
-	out, err := g.run("cat-file", "-e", g.ref+":"+file)
-	return err == nil && len(out) == 0
+func (g *Git) exists(ref, file string) bool {
+	_, err := g.db.lookup(ref+":"+file, false)
+	return err == nil
 }
 
-// Utility: check if commit has parents
-func (g *Git) hasParents(commit string) bool {
-	out, err := g.run("rev-list", "--parents", "-n", "1", commit)
-	return err == nil && bytes.IndexByte(out, ' ') != -1
+// Utility: check if path is a directory according to git
+func (g *Git) isTree(ref, path string) bool {
+	obj, err := g.db.lookup(ref+":"+path, false)
+	return err == nil && obj.typ == "tree"
 }
 
-// Utility: run command with timeout
+// Utility: retrieve the contents of the file at path, ErrNotExist if path is
+// missing or not a file
+func (g *Git) blob(ref, path string) (*object, error) {
+	obj, err := g.db.lookup(ref+":"+path, true)
+	if err == nil && obj.typ != "blob" {
+		err = ErrNotExist
+	}
+	return obj, err
+}
+
+// Utility: run command with timeout, ErrUnsupported if the backend is native
 func (g *Git) run(arg ...string) ([]byte, error) {
+	if g.native {
+		return nil, ErrUnsupported
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
 	defer cancel()
 
-	arg = append([]string{"-P", "-C", g.path}, arg...)
-
-	cmd := exec.CommandContext(ctx, "git", arg...)
-	cmd.Env = []string{"COLUMNS=80"}
-	b, err := cmd.Output()
+	b, err := g.command(ctx, arg...).Output()
 	if ctx.Err() == context.DeadlineExceeded {
 		err = ctx.Err()
 	}
 	return b, err
 }
+
+// Utility: create command in the repository
+func (g *Git) command(ctx context.Context, arg ...string) *exec.Cmd {
+	arg = append([]string{"-P", "-C", g.path}, arg...)
+
+	cmd := exec.CommandContext(ctx, "git", arg...)
+	cmd.Env = []string{"COLUMNS=80"}
+	return cmd
+}
@@ -0,0 +1,179 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRepo is a repository created for a test, with fixed identities and dates
+// so the hashes of its objects don't change.
+type testRepo struct {
+	t       *testing.T
+	dir     string
+	commits int
+}
+
+// Create an empty repository with the branch main, skipping the test if git
+// isn't installed.
+func newTestRepo(t *testing.T, arg ...string) *testRepo {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	dir, err := ioutil.TempDir("", "gitweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	r := &testRepo{t: t, dir: dir}
+	r.git(append([]string{"init", "-q", "-b", "main"}, arg...)...)
+	return r
+}
+
+// Run git in the repository, returning its output without the final newline.
+func (r *testRepo) git(arg ...string) string {
+	r.t.Helper()
+
+	// each commit is a minute after the last, so they sort by date
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).
+		Add(time.Duration(r.commits) * time.Minute).Format(time.RFC3339)
+
+	cmd := exec.Command("git", arg...)
+	cmd.Dir = r.dir
+	cmd.Env = []string{
+		"HOME=" + r.dir,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=A U Thor",
+		"GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_AUTHOR_DATE=" + date,
+		"GIT_COMMITTER_NAME=C O Mitter",
+		"GIT_COMMITTER_EMAIL=committer@example.com",
+		"GIT_COMMITTER_DATE=" + date,
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("%v: %s", err, e.Stderr)
+		}
+		r.t.Fatalf("git %s: %v", strings.Join(arg, " "), err)
+	}
+
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+// Commit all changes of the work tree.
+func (r *testRepo) commit(arg ...string) {
+	r.t.Helper()
+
+	r.git("add", "-A")
+	r.git(append([]string{"commit", "-q"}, arg...)...)
+	r.commits++
+}
+
+// Write a file of the work tree, creating its directories.
+func (r *testRepo) write(name, contents string) {
+	r.t.Helper()
+
+	name = filepath.Join(r.dir, name)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		r.t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(name, []byte(contents), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// Open the repository with each backend.
+func (r *testRepo) open() map[string]*Git {
+	r.t.Helper()
+
+	ret := make(map[string]*Git)
+
+	for _, backend := range []string{BackendExec, BackendNative} {
+		g, err := NewGit(r.dir, "main", backend, 10*time.Second, 1<<20)
+		if err != nil {
+			r.t.Fatal(backend, err)
+		}
+		ret[backend] = g
+	}
+
+	return ret
+}
+
+func TestResolve(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a", "a\n")
+	r.commit("-m", "first")
+	r.write("a", "b\n")
+	r.commit("-m", "second")
+
+	head := r.git("rev-parse", "main")
+
+	for backend, g := range r.open() {
+		for _, name := range []string{"main", "HEAD", "main~0",
+			head[:7], head} {
+			if hash, err := g.Resolve(name); err != nil || hash != head {
+				t.Errorf("%s: %s: got %s, %v", backend, name, hash, err)
+			}
+		}
+
+		// history searches and the reflog
+		for _, name := range []string{"", "-h", ":/first", ":a",
+			"main@{0}", "@{-1}", "main^{/first}"} {
+			if _, err := g.Resolve(name); err != ErrInvalidRef {
+				t.Errorf("%s: %q: got %v", backend, name, err)
+			}
+		}
+	}
+}
+
+func TestSHA256(t *testing.T) {
+	r := newTestRepo(t, "--object-format=sha256")
+	r.write("a", "a\n")
+	r.commit("-m", "root")
+
+	root := r.git("rev-parse", "main")
+	if len(root) != 64 {
+		t.Fatalf("got hash %s", root)
+	}
+
+	for backend, g := range r.open() {
+		if !g.IsHash(root) || g.IsHash(root[:40]) ||
+			g.IsHash(strings.ToUpper(root)) {
+			t.Errorf("%s: IsHash by length", backend)
+		}
+
+		// the 40 character prefix is an abbreviation
+		if hash, err := g.Resolve(root[:40]); err != nil || hash != root {
+			t.Errorf("%s: got %s, %v", backend, hash, err)
+		}
+
+		if _, err := g.Commit(root[:40], 0, DiffOptions{}); err !=
+			ErrInvalidHash {
+			t.Errorf("%s: commit by abbreviation: got %v", backend, err)
+		}
+
+		c, err := g.Commit(root, 0, DiffOptions{Context: -1})
+		if err != nil {
+			t.Fatal(backend, err)
+		}
+
+		// root commits are diffed against the empty tree of the format
+		if backend == BackendExec && (len(c.Diff) != 1 ||
+			c.Diff.Stat() != LogStat{1, 1, 0}) {
+			t.Errorf("%s: got diff %+v", backend, c.Diff)
+		}
+	}
+}
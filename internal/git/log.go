@@ -26,30 +26,76 @@ type LogItem struct {
 	Stat    LogStat
 }
 
-// Log retrieves the simple commit history.
-func (g *Git) Log() ([]*LogItem, error) {
-	const l = 6
+// Log retrieves the simple commit history of ref, skipping the first skip
+// commits and returning at most count commits if count is positive. A
+// non-empty path restricts the history to that file or directory, following
+// renames of files.
+func (g *Git) Log(ref, path string, skip, count int) ([]*LogItem, error) {
+	hash, err := g.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var file bool
+
+	if path != "" {
+		if !g.exists(hash, path) {
+			return nil, ErrNotExist
+		}
+
+		file = !g.isTree(hash, path)
+	}
+
+	return g.db.log(hash, path, file, skip, count)
+}
+
+func (b *execBackend) log(hash, path string, file bool, skip,
+	count int) ([]*LogItem, error) {
+	arg := []string{"log", logFormat, "--shortstat",
+		"--skip=" + strconv.Itoa(skip)}
+
+	if count > 0 {
+		arg = append(arg, "--max-count="+strconv.Itoa(count))
+	}
+
+	if file {
+		arg = append(arg, "--follow")
+	}
 
-	out, err := g.run("log", "--format=%aI%n%H%n%an%n%s",
-		"--shortstat", g.ref)
+	arg = append(arg, hash, "--")
+
+	if path != "" {
+		arg = append(arg, path)
+	}
+
+	out, err := b.g.run(arg...)
 	if err != nil {
 		return nil, err
 	}
 
-	lines := bytes.Split(out[:len(out)-1], []byte{'\n'})
+	return parseLog(out)
+}
 
-	if len(lines)%l != 0 {
-		return nil, errors.New("git: log: output line count mismatch")
+const logFormat = "--format=%x00%aI%n%H%n%an%n%s"
+
+// Utility: parse the output of git log with logFormat and --shortstat
+func parseLog(out []byte) ([]*LogItem, error) {
+	if len(out) == 0 {
+		return nil, nil
 	}
 
-	ret := make([]*LogItem, len(lines)/l)
+	// each commit starts with a NUL, since commits without changes (such as
+	// merges) have no shortstat lines
+	records := bytes.Split(out[1:], []byte{0})
+
+	ret := make([]*LogItem, len(records))
 	p := pool.New(5, 100)
 	errs := make(chan error, 1)
 	defer close(errs)
 
 	f := func(args ...interface{}) {
 		var err error
-		ret[args[0].(int)/l], err = parseLogItem(args[1].([][]byte))
+		ret[args[0].(int)], err = parseLogItem(args[1].([][]byte))
 		if err != nil {
 			select {
 			case errs <- err:
@@ -58,13 +104,21 @@ func (g *Git) Log() ([]*LogItem, error) {
 		}
 	}
 
-	for i := 0; i < len(lines); i += l {
-		p.Enlist(true, f, i, lines[i:i+l])
+	for i, record := range records {
+		// only the terminator is trimmed, since the subject may be empty
+		lines := bytes.Split(bytes.TrimSuffix(record, []byte{'\n'}),
+			[]byte{'\n'})
+		p.Enlist(true, f, i, lines)
 	}
 
 	p.Close(false)
 
-	return ret, nil
+	select {
+	case err := <-errs:
+		return nil, err
+	default:
+		return ret, nil
+	}
 }
 
 var reNum = regexp.MustCompile("[0-9]+")
@@ -74,8 +128,12 @@ func parseLogItem(raw [][]byte) (item *LogItem, err error) {
 	// raw[1] = hash
 	// raw[2] = author
 	// raw[3] = subject
-	// raw[4] = empty line
-	// raw[5] = shortstat (files changed, insertions, deletions)
+	// raw[4] = empty line, if any changes
+	// raw[5] = shortstat (files changed, insertions, deletions), if any changes
+
+	if len(raw) != 4 && len(raw) != 6 {
+		return nil, errors.New("git: log: output line count mismatch")
+	}
 
 	item = &LogItem{}
 
@@ -91,6 +149,10 @@ func parseLogItem(raw [][]byte) (item *LogItem, err error) {
 	item.Name = string(raw[2])
 	item.Subject = string(raw[3])
 
+	if len(raw) == 4 {
+		return
+	}
+
 	// nums[0] = file(s) changed
 	// nums[1] = insertions
 	// nums[2] = deletions
@@ -0,0 +1,80 @@
+package git
+
+import (
+	"testing"
+)
+
+func TestParseLog(t *testing.T) {
+	const (
+		date = "2020-01-02T03:04:05+00:00"
+		hash = "0123456789abcdef0123456789abcdef01234567"
+		stat = " 1 file changed, 2 insertions(+), 3 deletions(-)"
+	)
+
+	tests := []struct {
+		name    string
+		out     string
+		subject string
+		stat    LogStat
+	}{
+		{"changes", "\x00" + date + "\n" + hash + "\nA\nsubject\n\n" +
+			stat + "\n", "subject", LogStat{1, 2, 3}},
+		{"no changes", "\x00" + date + "\n" + hash + "\nA\nsubject\n",
+			"subject", LogStat{}},
+		{"empty subject", "\x00" + date + "\n" + hash + "\nA\n\n\n" +
+			stat + "\n", "", LogStat{1, 2, 3}},
+		{"empty subject, no changes", "\x00" + date + "\n" + hash +
+			"\nA\n\n", "", LogStat{}},
+	}
+
+	for _, test := range tests {
+		items, err := parseLog([]byte(test.out))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(items) != 1 {
+			t.Errorf("%s: got %d items", test.name, len(items))
+			continue
+		}
+
+		item := items[0]
+		if item.Hash != hash || item.Name != "A" ||
+			item.Subject != test.subject || item.Stat != test.stat {
+			t.Errorf("%s: got %+v", test.name, item)
+		}
+	}
+}
+
+func TestLogEmptyMessage(t *testing.T) {
+	r := newTestRepo(t)
+	r.write("a", "a\n")
+	r.commit("-m", "first")
+	r.write("a", "a\nb\n")
+	r.commit("--allow-empty-message", "-m", "")
+	r.commit("--allow-empty", "--allow-empty-message", "-m", "")
+
+	for backend, g := range r.open() {
+		items, err := g.Log("main", "", 0, 0)
+		if err != nil {
+			t.Errorf("%s: %v", backend, err)
+			continue
+		}
+
+		var subjects []string
+		for _, item := range items {
+			subjects = append(subjects, item.Subject)
+		}
+
+		if len(items) != 3 || items[0].Subject != "" ||
+			items[1].Subject != "" || items[2].Subject != "first" {
+			t.Errorf("%s: got subjects %q", backend, subjects)
+			continue
+		}
+
+		if items[1].Stat != (LogStat{1, 1, 0}) {
+			t.Errorf("%s: got stat %+v", backend, items[1].Stat)
+		}
+	}
+}
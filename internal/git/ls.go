@@ -1,21 +1,18 @@
 package git
 
 import (
-	"bytes"
-	"errors"
 	"os"
-	"strconv"
-
-	"github.com/esote/util/pool"
+	"sort"
 )
 
 // Tree object types
 const (
 	LsBlob = iota
 	LsTree
+	LsCommit
 )
 
-// LsItem is the parsed columnar output of git ls-tree.
+// LsItem is an entry of a tree.
 type LsItem struct {
 	Mode os.FileMode
 	Type int
@@ -24,88 +21,67 @@ type LsItem struct {
 	Name string
 }
 
-// Ls retrieves the list of tracked files.
-func (g *Git) Ls() ([]*LsItem, error) {
-	out, err := g.run("ls-tree", "-lr", g.ref)
-	if err != nil {
-		return nil, err
-	}
-
-	lines := bytes.Split(out[:len(out)-1], []byte{'\n'})
-	ret := make([]*LsItem, len(lines))
-	p := pool.New(5, 100)
-	errs := make(chan error, 1)
-	defer close(errs)
-
-	f := func(args ...interface{}) {
-		var err error
-		ret[args[0].(int)], err = parseLsItem(args[1].([]byte))
-		if err != nil {
-			select {
-			case errs <- err:
-			default:
-			}
-		}
-	}
-
-	for i := range lines {
-		p.Enlist(true, f, i, lines[i])
-	}
-
-	p.Close(false)
-
-	return ret, nil
+// IsTree reports whether the item is a directory.
+func (item *LsItem) IsTree() bool {
+	return item.Type == LsTree
 }
 
-func parseLsItem(raw []byte) (item *LsItem, err error) {
-	// ls-tree with -l has 3 space sep and 1 tab sep
+// IsBlob reports whether the item is a file.
+func (item *LsItem) IsBlob() bool {
+	return item.Type == LsBlob
+}
 
-	// first[0] = mode
-	// first[1] = type
-	// first[2] = hash
-	// first[3] = size and name
-	first := bytes.SplitN(raw, []byte{' '}, 4)
-	if len(first) != 4 {
-		return nil, errors.New("git: ls: first split failed")
+// Ls retrieves the entries of the directory at path in ref, trees first. An
+// empty path is the root of the repository.
+func (g *Git) Ls(ref, path string) ([]*LsItem, error) {
+	if err := g.verify(ref); err != nil {
+		return nil, err
 	}
 
-	// second[0] = size
-	// second[1] = name
-	second := bytes.SplitN(first[3], []byte{'\t'}, 2)
-	if len(second) != 2 {
-		return nil, errors.New("git: ls: second split failed")
+	obj, err := g.db.lookup(ref+":"+path, true)
+	if err != nil {
+		return nil, err
 	}
 
-	item = &LsItem{}
+	if obj.typ != "tree" {
+		return nil, ErrNotExist
+	}
 
-	mode, err := strconv.ParseUint(string(first[0]), 10, 32)
+	entries, err := parseTree(obj.data, len(obj.hash)/2)
 	if err != nil {
 		return nil, err
 	}
 
-	item.Mode = os.FileMode(mode)
+	ret := make([]*LsItem, len(entries))
 
-	switch string(first[1]) {
-	case "blob":
-		item.Type = LsBlob
-	case "tree":
-		item.Type = LsTree
-	default:
-		return nil, errors.New("git: ls: unknown object type")
-	}
+	for i, e := range entries {
+		item := &LsItem{
+			Mode: e.fileMode(),
+			Hash: e.hash,
+			Name: e.name,
+		}
 
-	item.Hash = string(first[2])
-	second[0] = bytes.TrimLeft(second[0], " ")
+		switch e.typ() {
+		case "blob":
+			item.Type = LsBlob
 
-	if second[0][0] == '-' {
-		item.Size = 0
-	} else {
-		item.Size, err = strconv.ParseInt(string(second[0]), 10, 64)
-		if err != nil {
-			return nil, err
+			blob, err := g.db.lookup(e.hash, false)
+			if err != nil {
+				return nil, err
+			}
+			item.Size = blob.size
+		case "tree":
+			item.Type = LsTree
+		case "commit":
+			item.Type = LsCommit
 		}
+
+		ret[i] = item
 	}
 
-	item.Name = string(second[1])
-	return
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].IsTree() && !ret[j].IsTree()
+	})
+
+	return ret, nil
 }
@@ -0,0 +1,928 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nativeBackend reads refs, loose objects and packfiles directly from the git
+// directory, without running git.
+type nativeBackend struct {
+	dir     string
+	objects []string
+	hashLen int
+	timeout time.Duration
+	maxSize int64
+
+	mu      sync.Mutex
+	packs   []*pack
+	packed  map[string]string
+	shallow map[string]bool
+	bases   map[packOffset]*object
+
+	basesSize int64
+
+	// modification times of the pack directories, packed-refs and shallow
+	// when they were last read
+	packsMod   []time.Time
+	packedMod  time.Time
+	shallowMod time.Time
+}
+
+// errNotRepository is returned if the native backend can't find a git
+// directory.
+var errNotRepository = errors.New("git: not a git repository")
+
+// openNative finds the git directory of the repository at path, which is
+// either bare or a work tree.
+func openNative(path string, timeout time.Duration,
+	maxSize int64) (*nativeBackend, error) {
+	dir, err := gitDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &nativeBackend{
+		dir:     dir,
+		hashLen: 20,
+		timeout: timeout,
+		maxSize: maxSize,
+	}
+
+	if format, err := objectFormat(dir); err != nil {
+		return nil, err
+	} else if format == "sha256" {
+		b.hashLen = 32
+	}
+
+	objects := filepath.Join(dir, "objects")
+	b.objects = []string{objects}
+
+	// alternates are object directories shared with other repositories,
+	// one per line and relative to our object directory
+	alternates, err := ioutil.ReadFile(filepath.Join(objects, "info",
+		"alternates"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(alternates), "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(objects, line)
+		}
+
+		b.objects = append(b.objects, line)
+	}
+
+	b.packsMod = make([]time.Time, len(b.objects))
+	return b, nil
+}
+
+// Utility: find the git directory of a repository
+func gitDir(path string) (string, error) {
+	dir := filepath.Join(path, ".git")
+
+	fi, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		// bare
+		dir = path
+	case err != nil:
+		return "", err
+	case !fi.IsDir():
+		// linked work trees and submodules have a "gitdir: path" file
+		b, err := ioutil.ReadFile(dir)
+		if err != nil {
+			return "", err
+		}
+
+		if !bytes.HasPrefix(b, []byte("gitdir: ")) {
+			return "", errNotRepository
+		}
+
+		dir = string(bytes.TrimSpace(b[len("gitdir: "):]))
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(path, dir)
+		}
+	}
+
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return "", errNotRepository
+		}
+	}
+
+	return dir, nil
+}
+
+// Utility: read extensions.objectformat from the repository config, which
+// selects SHA-256 object names
+func objectFormat(dir string) (string, error) {
+	vars, err := readConfig(dir, "extensions")
+	if err != nil {
+		return "", err
+	}
+
+	var format string
+	for _, v := range vars {
+		if v[0] == "extensions.objectformat" {
+			format = strings.ToLower(v[1])
+		}
+	}
+
+	return format, nil
+}
+
+func (b *nativeBackend) lookup(name string, contents bool) (*object, error) {
+	// rev:path names the object at path in the tree of rev
+	rev, path := name, ""
+	tree := false
+	if i := strings.IndexByte(name, ':'); i != -1 {
+		rev, path, tree = name[:i], name[i+1:], true
+	}
+
+	hash, err := b.revision(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	if tree {
+		if hash, err = b.peel(hash, "tree"); err != nil {
+			return nil, err
+		}
+
+		var mode uint32
+		if hash, mode, err = b.treePath(hash, path); err != nil {
+			return nil, err
+		}
+
+		// submodules are commits in another repository
+		if mode&0170000 == 0160000 {
+			return nil, ErrNotExist
+		}
+	}
+
+	if !contents {
+		typ, size, err := b.info(hash)
+		if err != nil {
+			return nil, err
+		}
+		return &object{hash: hash, typ: typ, size: size}, nil
+	}
+
+	return b.read(hash)
+}
+
+// Objects are read whole, so ctx isn't checked while streaming.
+func (b *nativeBackend) stream(ctx context.Context, hash string) (io.ReadCloser,
+	error) {
+	obj, err := b.read(hash)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+// Utility: resolve a revision such as "v1.0", "HEAD~2" or "abc123^{tree}" to
+// an object hash
+func (b *nativeBackend) revision(rev string) (string, error) {
+	// suffixes begin at the first ^ or ~, which refs can't contain
+	i := strings.IndexAny(rev, "^~")
+	if i == -1 {
+		i = len(rev)
+	}
+
+	hash, err := b.base(rev[:i])
+	if err != nil {
+		return "", err
+	}
+
+	for rev = rev[i:]; rev != ""; {
+		op := rev[0]
+		rev = rev[1:]
+
+		// ^{type} peels to type, and ^{} peels tags
+		if op == '^' && strings.HasPrefix(rev, "{") {
+			end := strings.IndexByte(rev, '}')
+			if end == -1 {
+				return "", ErrNotExist
+			}
+
+			typ := rev[1:end]
+			rev = rev[end+1:]
+
+			switch typ {
+			case "":
+				hash, err = b.peel(hash, "")
+			case "commit", "tree", "blob", "tag":
+				hash, err = b.peel(hash, typ)
+			case "object":
+			default:
+				err = ErrNotExist
+			}
+
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		// ^n is the nth parent and ~n the nth first-parent ancestor, both
+		// with n defaulting to 1
+		j := 0
+		for j < len(rev) && rev[j] >= '0' && rev[j] <= '9' {
+			j++
+		}
+
+		n := 1
+		if j > 0 {
+			if n, err = strconv.Atoi(rev[:j]); err != nil {
+				return "", ErrNotExist
+			}
+		}
+		rev = rev[j:]
+
+		if hash, err = b.peel(hash, "commit"); err != nil {
+			return "", err
+		}
+
+		if op == '^' {
+			if n == 0 {
+				continue
+			}
+			hash, err = b.parent(hash, n)
+		} else {
+			for ; n > 0 && err == nil; n-- {
+				hash, err = b.parent(hash, 1)
+			}
+		}
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hash, nil
+}
+
+// Utility: resolve a full hash, ref name or abbreviated hash, in the order git
+// tries them
+func (b *nativeBackend) base(name string) (string, error) {
+	if name == "" {
+		return "", ErrNotExist
+	}
+
+	if len(name) == b.hashLen*2 && isHex(name) {
+		return strings.ToLower(name), nil
+	}
+
+	for _, format := range []string{"%s", "refs/%s", "refs/tags/%s",
+		"refs/heads/%s", "refs/remotes/%s", "refs/remotes/%s/HEAD"} {
+		full := fmt.Sprintf(format, name)
+
+		// only refs/ and names like HEAD are looked up as-is
+		if format == "%s" && !strings.HasPrefix(name, "refs/") &&
+			strings.ToUpper(name) != name {
+			continue
+		}
+
+		hash, err := b.resolveRef(full)
+		if err == nil {
+			return hash, nil
+		}
+		if err != ErrNotExist {
+			return "", err
+		}
+	}
+
+	if len(name) >= 4 && isHex(name) {
+		return b.abbrev(strings.ToLower(name))
+	}
+
+	return "", ErrNotExist
+}
+
+// Utility: peel tags, and commits to their tree, until reaching an object of
+// type typ, or any object other than a tag if typ is empty
+func (b *nativeBackend) peel(hash, typ string) (string, error) {
+	for {
+		obj, err := b.read(hash)
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case obj.typ == typ || typ == "" && obj.typ != "tag":
+			return hash, nil
+		case obj.typ == "tag":
+			if hash, _, err = parseTag(obj.data); err != nil {
+				return "", err
+			}
+		case obj.typ == "commit" && typ == "tree":
+			c, err := parseCommit(obj.data)
+			if err != nil {
+				return "", err
+			}
+			return c.tree, nil
+		default:
+			return "", ErrNotExist
+		}
+	}
+}
+
+// Utility: find the nth parent of a commit
+func (b *nativeBackend) parent(hash string, n int) (string, error) {
+	c, err := b.commit(hash)
+	if err != nil {
+		return "", err
+	}
+
+	if n > len(c.parents) {
+		return "", ErrNotExist
+	}
+
+	return c.parents[n-1], nil
+}
+
+// Utility: read and parse a commit
+func (b *nativeBackend) commit(hash string) (*commitObject, error) {
+	obj, err := b.read(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.typ != "commit" {
+		return nil, ErrNotExist
+	}
+
+	c, err := parseCommit(obj.data)
+	if err != nil {
+		return nil, err
+	}
+
+	// the parents of commits at the edge of a shallow clone are missing
+	shallow, err := b.shallowCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	if shallow[hash] {
+		c.parents = nil
+	}
+
+	return c, nil
+}
+
+// Utility: read the list of shallow commits, or reuse the last read if
+// unchanged
+func (b *nativeBackend) shallowCommits() (map[string]bool, error) {
+	path := filepath.Join(b.dir, "shallow")
+
+	var mod time.Time
+	fi, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		mod = fi.ModTime()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.shallow != nil && mod.Equal(b.shallowMod) {
+		return b.shallow, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	shallow := make(map[string]bool)
+	for _, hash := range strings.Fields(string(data)) {
+		shallow[hash] = true
+	}
+
+	b.shallow, b.shallowMod = shallow, mod
+	return shallow, nil
+}
+
+// Utility: find the entry at path within a tree, the tree itself if path is
+// empty
+func (b *nativeBackend) treePath(tree, path string) (string, uint32, error) {
+	hash, mode := tree, uint32(0040000)
+
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+
+		if mode&0170000 != 0040000 {
+			return "", 0, ErrNotExist
+		}
+
+		obj, err := b.read(hash)
+		if err != nil {
+			return "", 0, err
+		}
+
+		entries, err := parseTree(obj.data, b.hashLen)
+		if err != nil {
+			return "", 0, err
+		}
+
+		i := sort.Search(len(entries), func(i int) bool {
+			return treeName(entries[i]) >= name
+		})
+
+		// entries are sorted as if trees end with a slash, so the entry
+		// may be just before a tree with the same name
+		found := false
+		for ; i < len(entries) && strings.HasPrefix(entries[i].name,
+			name); i++ {
+			if entries[i].name == name {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return "", 0, ErrNotExist
+		}
+
+		hash, mode = entries[i].hash, entries[i].mode
+	}
+
+	return hash, mode, nil
+}
+
+// Utility: the name a tree entry is sorted by
+func treeName(e treeEntry) string {
+	if e.typ() == "tree" {
+		return e.name + "/"
+	}
+	return e.name
+}
+
+func (b *nativeBackend) refs() ([]refEntry, error) {
+	var names []string
+
+	root := filepath.Join(b.dir, "refs")
+	err := filepath.Walk(root, func(path string, fi os.FileInfo,
+		err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.Mode().IsRegular() {
+			names = append(names, filepath.ToSlash(path[len(b.dir)+1:]))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	packed, err := b.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range packed {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var ret []refEntry
+
+	for i, name := range names {
+		// loose refs which are also packed
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+
+		hash, err := b.resolveRef(name)
+		if err == ErrNotExist {
+			// a dangling symbolic ref
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, refEntry{name: name, hash: hash})
+	}
+
+	return ret, nil
+}
+
+// Utility: resolve a full ref name, following symbolic refs
+func (b *nativeBackend) resolveRef(name string) (string, error) {
+	// git gives up on symbolic refs nested deeper than this
+	const maxDepth = 5
+
+	for depth := 0; depth < maxDepth; depth++ {
+		if !validRef(name) {
+			return "", ErrNotExist
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(b.dir,
+			filepath.FromSlash(name)))
+		if os.IsNotExist(err) || err == nil && len(data) == 0 {
+			packed, err := b.packedRefs()
+			if err != nil {
+				return "", err
+			}
+
+			if hash, ok := packed[name]; ok {
+				return hash, nil
+			}
+			return "", ErrNotExist
+		} else if err != nil {
+			// such as a directory of refs
+			return "", ErrNotExist
+		}
+
+		data = bytes.TrimSpace(data)
+
+		if !bytes.HasPrefix(data, []byte("ref: ")) {
+			if len(data) != b.hashLen*2 || !isHex(string(data)) {
+				return "", errors.New("git: malformed ref " + name)
+			}
+			return string(data), nil
+		}
+
+		name = string(data[len("ref: "):])
+	}
+
+	return "", ErrNotExist
+}
+
+// Utility: check if name is a safe ref name to read from the git directory,
+// which is stricter than git-check-ref-format
+func validRef(name string) bool {
+	if name == "" || strings.ContainsAny(name, " ~^:?*[\\") ||
+		strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return false
+	}
+
+	for _, c := range name {
+		if c < ' ' || c == 0x7f {
+			return false
+		}
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part[0] == '.' || strings.HasSuffix(part, ".lock") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Utility: read packed-refs, or reuse the last read if unchanged
+func (b *nativeBackend) packedRefs() (map[string]string, error) {
+	path := filepath.Join(b.dir, "packed-refs")
+
+	var mod time.Time
+	fi, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		mod = fi.ModTime()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.packed != nil && mod.Equal(b.packedMod) {
+		return b.packed, nil
+	}
+
+	packed := make(map[string]string)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// "hash name" lines, each optionally followed by a "^hash" line with
+	// the peeled hash of an annotated tag
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, errors.New("git: malformed packed-refs")
+		}
+
+		packed[fields[1]] = fields[0]
+	}
+
+	b.packed, b.packedMod = packed, mod
+	return packed, nil
+}
+
+// Utility: read an object by hash
+func (b *nativeBackend) read(hash string) (*object, error) {
+	raw, err := b.rawHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if path, ok := b.loose(hash); ok {
+		return readLoose(hash, path, b.maxSize)
+	}
+
+	p, off, err := b.find(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.readPacked(p, off, hash)
+}
+
+// Utility: find the type and size of an object by hash, without reading all
+// of it
+func (b *nativeBackend) info(hash string) (string, int64, error) {
+	raw, err := b.rawHash(hash)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if path, ok := b.loose(hash); ok {
+		return infoLoose(path)
+	}
+
+	p, off, err := b.find(raw)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return b.infoPacked(p, off, 0)
+}
+
+// Utility: decode a hex hash of the right length
+func (b *nativeBackend) rawHash(hash string) ([]byte, error) {
+	if len(hash) != b.hashLen*2 {
+		return nil, ErrNotExist
+	}
+
+	raw, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, ErrNotExist
+	}
+	return raw, nil
+}
+
+// Utility: find the path of a loose object
+func (b *nativeBackend) loose(hash string) (string, bool) {
+	for _, dir := range b.objects {
+		path := filepath.Join(dir, hash[:2], hash[2:])
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Utility: resolve an abbreviated hash, which must be unique
+func (b *nativeBackend) abbrev(prefix string) (string, error) {
+	found := make(map[string]bool)
+
+	for _, dir := range b.objects {
+		names, err := ioutil.ReadDir(filepath.Join(dir, prefix[:2]))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+
+		for _, fi := range names {
+			if hash := prefix[:2] + fi.Name(); strings.HasPrefix(hash,
+				prefix) && len(hash) == b.hashLen*2 {
+				found[hash] = true
+			}
+		}
+	}
+
+	packs, err := b.loadPacks(false)
+	if err != nil {
+		return "", err
+	}
+
+	for _, p := range packs {
+		for _, hash := range p.prefixed(prefix) {
+			found[hash] = true
+		}
+	}
+
+	if len(found) != 1 {
+		return "", ErrNotExist
+	}
+
+	for hash := range found {
+		return hash, nil
+	}
+	return "", ErrNotExist
+}
+
+// Utility: find the pack containing an object, rereading the pack
+// directories if it may have been added since they were last read
+func (b *nativeBackend) find(raw []byte) (*pack, int64, error) {
+	for _, reload := range []bool{false, true} {
+		packs, err := b.loadPacks(reload)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, p := range packs {
+			if off, ok := p.find(raw); ok {
+				return p, off, nil
+			}
+		}
+	}
+
+	return nil, 0, ErrNotExist
+}
+
+// Utility: list the packs, rereading any changed pack directories if reload
+// is set or they were never read
+func (b *nativeBackend) loadPacks(reload bool) ([]*pack, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.packs != nil && !reload {
+		return b.packs, nil
+	}
+
+	changed := b.packs == nil
+	mods := make([]time.Time, len(b.objects))
+
+	for i, dir := range b.objects {
+		fi, err := os.Stat(filepath.Join(dir, "pack"))
+		if err == nil {
+			mods[i] = fi.ModTime()
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if !mods[i].Equal(b.packsMod[i]) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return b.packs, nil
+	}
+
+	old := make(map[string]*pack)
+	for _, p := range b.packs {
+		old[p.path] = p
+	}
+
+	packs := []*pack{}
+
+	for _, dir := range b.objects {
+		idx, err := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range idx {
+			path = strings.TrimSuffix(path, ".idx")
+
+			if p, ok := old[path]; ok {
+				packs = append(packs, p)
+				continue
+			}
+
+			p, err := openPack(path, b.hashLen)
+			if os.IsNotExist(err) {
+				// removed by a concurrent repack
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			packs = append(packs, p)
+		}
+	}
+
+	b.packs, b.packsMod = packs, mods
+	return packs, nil
+}
+
+// Utility: read a loose object, ErrTooLarge if larger than maxSize
+func readLoose(hash, path string, maxSize int64) (*object, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	z, err := zlib.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+
+	r := bufio.NewReader(z)
+
+	typ, size, err := looseHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkSize(size, fi.Size(), maxSize); err != nil {
+		return nil, err
+	}
+
+	obj := &object{
+		hash: hash,
+		typ:  typ,
+		size: size,
+		data: make([]byte, size),
+	}
+
+	if _, err = io.ReadFull(r, obj.data); err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// Utility: read the type and size of a loose object
+func infoLoose(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	z, err := zlib.NewReader(f)
+	if err != nil {
+		return "", 0, err
+	}
+	defer z.Close()
+
+	return looseHeader(bufio.NewReader(z))
+}
+
+func looseHeader(r *bufio.Reader) (string, int64, error) {
+	// "type size\x00"
+	header, err := r.ReadString(0)
+	if err != nil {
+		return "", 0, err
+	}
+
+	fields := strings.SplitN(header[:len(header)-1], " ", 2)
+	if len(fields) != 2 {
+		return "", 0, errors.New("git: malformed loose object")
+	}
+
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, errors.New("git: malformed loose object")
+	}
+
+	return fields[0], size, nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' ||
+			'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,458 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Create a repository with packed objects and refs, as ofs deltas or ref
+// deltas, and then loose objects and refs on top of them.
+func newFixtureRepo(t *testing.T, refDeltas bool) *testRepo {
+	t.Helper()
+
+	r := newTestRepo(t)
+	if refDeltas {
+		r.git("config", "repack.useDeltaBaseOffset", "false")
+	}
+
+	// long files changed a little between commits, so they are packed as
+	// deltas
+	var readme strings.Builder
+	for i := 0; i < 200; i++ {
+		readme.WriteString(strings.Repeat("line ", i%7+1) + "\n")
+	}
+
+	r.write("README.md", readme.String())
+	r.write("dir/a.txt", "a\nb\nc\n")
+	r.write("dir/sub/b.txt", "b\n")
+	r.write("bin", "\x00\x01\x02")
+	r.commit("-m", "first")
+
+	r.write("README.md", "new first line\n"+readme.String()+"end\n")
+	r.write("dir/a.txt", "a\nB\nc\nd\n")
+	r.write("c.txt", "c\n")
+	r.commit("-m", "second", "-m", "body")
+
+	r.git("mv", "dir/a.txt", "dir/moved.txt")
+	r.git("rm", "-q", "dir/sub/b.txt")
+	r.commit("-m", "rename and delete")
+
+	r.git("branch", "feature")
+	r.git("tag", "-a", "v1", "-m", "v1")
+	r.git("tag", "-a", "v1-outer", "-m", "outer", "v1")
+	r.git("tag", "light")
+	r.git("repack", "-a", "-d", "-f", "-q")
+	r.git("pack-refs", "--all")
+
+	r.write("README.md", readme.String()+"loose\n")
+	r.commit("-m", "loose")
+	r.git("tag", "v2")
+
+	return r
+}
+
+func TestNativeFixture(t *testing.T) {
+	for _, refDeltas := range []bool{false, true} {
+		name := "ofs deltas"
+		if refDeltas {
+			name = "ref deltas"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			r := newFixtureRepo(t, refDeltas)
+			gits := r.open()
+			exec, native := gits[BackendExec], gits[BackendNative]
+
+			testFixturePack(t, native, refDeltas)
+			testNativeObjects(t, r, exec, native)
+			testNativeNames(t, exec, native)
+			testNativeRefs(t, exec, native)
+			testNativeLog(t, exec, native)
+			testNativeLs(t, exec, native)
+		})
+	}
+}
+
+// Check the fixture has the loose objects and the kind of deltas it should.
+func testFixturePack(t *testing.T, native *Git, refDeltas bool) {
+	nb := native.db.(*nativeBackend)
+
+	packs, err := nb.loadPacks(false)
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("got %d packs, %v", len(packs), err)
+	}
+
+	p := packs[0]
+	f, err := os.Open(p.path + ".pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	kinds := make(map[int]int)
+	for i := 0; i < p.count; i++ {
+		off, ok := p.find(p.hash(i))
+		if !ok {
+			t.Fatalf("hash %d not found", i)
+		}
+
+		e, err := p.entry(f, off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kinds[e.typ]++
+	}
+
+	want, other := packOfsDelta, packRefDelta
+	if refDeltas {
+		want, other = other, want
+	}
+
+	if kinds[want] == 0 || kinds[other] != 0 {
+		t.Errorf("got pack entry types %v", kinds)
+	}
+
+	loose, _ := filepath.Glob(filepath.Join(nb.dir, "objects", "??", "*"))
+	if len(loose) == 0 {
+		t.Error("no loose objects")
+	}
+
+	if _, err = os.Stat(filepath.Join(nb.dir, "packed-refs")); err != nil {
+		t.Error(err)
+	}
+}
+
+// Check every object reads the same through both backends.
+func testNativeObjects(t *testing.T, r *testRepo, exec, native *Git) {
+	for _, line := range strings.Split(r.git("rev-list", "--objects",
+		"--all"), "\n") {
+		hash := strings.Fields(line)[0]
+
+		want, err := exec.db.lookup(hash, true)
+		if err != nil {
+			t.Fatal(hash, err)
+		}
+
+		for _, contents := range []bool{false, true} {
+			got, err := native.db.lookup(hash, contents)
+			if err != nil {
+				t.Errorf("%s: %v", hash, err)
+				continue
+			}
+
+			if got.hash != want.hash || got.typ != want.typ ||
+				got.size != want.size {
+				t.Errorf("%s: got %s %s %d, want %s %s %d", hash,
+					got.hash, got.typ, got.size, want.hash,
+					want.typ, want.size)
+			}
+
+			if contents && !bytes.Equal(got.data, want.data) {
+				t.Errorf("%s: contents differ", hash)
+			}
+		}
+	}
+}
+
+// Check names resolve to the same objects through both backends.
+func testNativeNames(t *testing.T, exec, native *Git) {
+	abbrev, err := exec.Resolve("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	abbrev = abbrev[:7]
+
+	names := []string{
+		"HEAD",
+		"main",
+		"feature",
+		"refs/heads/feature",
+		"v1",
+		"v1^{commit}",
+		"v1-outer",
+		"v1-outer^{commit}",
+		"v1-outer^{tree}",
+		"light",
+		"v2",
+		"main~1",
+		"main^",
+		"main~3",
+		"main^{tree}",
+		"main:README.md",
+		"main:dir",
+		"main:dir/moved.txt",
+		"v1:dir/moved.txt",
+		"feature~2:dir/sub/b.txt",
+		abbrev,
+		abbrev + "^{commit}",
+		"nope",
+		"main:nope",
+		"main~4",
+		"main:README.md/x",
+	}
+
+	for _, name := range names {
+		want, wantErr := exec.db.lookup(name, false)
+		got, err := native.db.lookup(name, false)
+
+		if err != wantErr {
+			t.Errorf("%s: got error %v, want %v", name, err, wantErr)
+			continue
+		}
+
+		if err == nil && (got.hash != want.hash || got.typ != want.typ ||
+			got.size != want.size) {
+			t.Errorf("%s: got %s %s %d, want %s %s %d", name, got.hash,
+				got.typ, got.size, want.hash, want.typ, want.size)
+		}
+	}
+}
+
+// Check the refs, read from packed-refs and loose refs, are the same.
+func testNativeRefs(t *testing.T, exec, native *Git) {
+	want, err := exec.db.refs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := native.db.refs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("refs: got %v, want %v", got, want)
+	}
+
+	wantItems, err := exec.Refs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotItems, err := native.Refs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gotItems) != len(wantItems) {
+		t.Fatalf("ref items: got %d, want %d", len(gotItems),
+			len(wantItems))
+	}
+
+	for i, want := range wantItems {
+		got := *gotItems[i]
+		if !got.Time.Equal(want.Time) {
+			t.Errorf("%s: got time %v, want %v", want.Name, got.Time,
+				want.Time)
+		}
+
+		got.Time = want.Time
+		if got != *want {
+			t.Errorf("got %+v, want %+v", got, *want)
+		}
+	}
+}
+
+// Check the history and its diff stats, which the native backend counts by
+// diffing trees, are the same.
+func testNativeLog(t *testing.T, exec, native *Git) {
+	tests := []struct {
+		ref, path string
+	}{
+		{"main", ""},
+		{"main", "README.md"},
+		{"main", "dir"},
+		{"feature~1", "dir/sub"},
+		{"main", "c.txt"},
+		{"v1-outer", "bin"},
+	}
+
+	for _, test := range tests {
+		want, err := exec.Log(test.ref, test.path, 0, 0)
+		if err != nil {
+			t.Fatal(test, err)
+		}
+
+		got, err := native.Log(test.ref, test.path, 0, 0)
+		if err != nil {
+			t.Errorf("%v: %v", test, err)
+			continue
+		}
+
+		if len(got) != len(want) {
+			t.Errorf("%v: got %d commits, want %d", test, len(got),
+				len(want))
+			continue
+		}
+
+		for i := range want {
+			g, w := *got[i], *want[i]
+			if !g.Time.Equal(w.Time) {
+				t.Errorf("%v: got time %v, want %v", test, g.Time,
+					w.Time)
+			}
+
+			g.Time = w.Time
+			if g != w {
+				t.Errorf("%v: got %+v, want %+v", test, g, w)
+			}
+		}
+	}
+
+	got, err := native.Log("main", "", 1, 2)
+	if err != nil || len(got) != 2 || got[0].Subject != "rename and delete" {
+		t.Errorf("skip and count: got %v, %v", got, err)
+	}
+}
+
+// Check trees list the same through both backends.
+func testNativeLs(t *testing.T, exec, native *Git) {
+	for _, path := range []string{"", "dir", "dir/sub"} {
+		for _, ref := range []string{"main", "v1-outer", "feature~2"} {
+			want, wantErr := exec.Ls(ref, path)
+			got, err := native.Ls(ref, path)
+
+			if err != wantErr || !reflect.DeepEqual(got, want) {
+				t.Errorf("%s:%s: got %v, %v, want %v, %v", ref, path,
+					got, err, want, wantErr)
+			}
+		}
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	r := newTestRepo(t)
+
+	f, err := os.OpenFile(filepath.Join(r.dir, ".git", "config"),
+		os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.WriteString(`[GitWeb]
+	Ref = main   ; comment
+	description = "quoted # not a comment  "
+	description = two\
+ lines
+	cacheDuration=24h#comment
+	flag = yes
+[gitweb "Sub"]
+	x = "a\tb\\c\"d"
+[gitwebx]
+	ref = other
+[other]
+	ref = other
+`)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gits := r.open()
+
+	want, err := gits[BackendExec].Config("gitweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := gits[BackendNative].Config("gitweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(want) != 6 || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	format, err := objectFormat(filepath.Join(r.dir, ".git"))
+	if err != nil || format != "" {
+		t.Errorf("object format: got %q, %v", format, err)
+	}
+}
+
+func TestReadConfigSyntax(t *testing.T) {
+	dir := newTestGitDir(t)
+
+	for _, config := range []string{
+		"[core\n",
+		"[core]\nx = \"unterminated\n",
+		"[core]\nx = bad \\q escape\n",
+	} {
+		err := writeConfig(dir, config)
+		if err == nil {
+			_, err = readConfig(dir, "core")
+		}
+
+		if err != errConfigSyntax {
+			t.Errorf("%q: got %v", config, err)
+		}
+	}
+}
+
+// Replace the config file of a git directory.
+func writeConfig(dir, config string) error {
+	return ioutil.WriteFile(filepath.Join(dir, "config"), []byte(config), 0644)
+}
+
+// Check the index of a pack lists where each object begins, including
+// objects past the 32-bit offsets.
+func TestPackLargeOffsets(t *testing.T) {
+	dir := newTestGitDir(t)
+
+	hashes := []string{
+		"1000000000000000000000000000000000000000",
+		"2000000000000000000000000000000000000000",
+	}
+
+	path := writeTestPack(t, dir, []testEntry{
+		{hash: hashes[0], typ: packBlob, data: []byte("a\n")},
+		{hash: hashes[1], typ: packBlob, data: []byte("b\n")},
+	})
+
+	p, err := openPack(path, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, ok := p.find(p.hash(0))
+	if !ok || first != 12 {
+		t.Fatalf("got %d, %v", first, ok)
+	}
+
+	// move the second offset into the table of 64-bit offsets
+	idx, err := ioutil.ReadFile(path + ".idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const header = 8 + 256*4
+	offsets := header + 2*20 + 2*4
+	trailer := append([]byte(nil), idx[offsets+2*4:]...)
+
+	binary.BigEndian.PutUint32(idx[offsets+4:], 0x80000000)
+	idx = append(idx[:offsets+2*4], make([]byte, 8)...)
+	binary.BigEndian.PutUint64(idx[offsets+2*4:], 1<<33)
+	idx = append(idx, trailer...)
+
+	if err = ioutil.WriteFile(path+".idx", idx, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err = openPack(path, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	if off, ok := p.find(p.hash(1)); !ok || off != 1<<33 {
+		t.Errorf("got %d, %v", off, ok)
+	}
+
+	if _, ok := p.find(make([]byte, 20)); ok {
+		t.Error("found missing hash")
+	}
+}
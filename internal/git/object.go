@@ -0,0 +1,213 @@
+package git
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// object is a git object as found by a backend. Data is only set for lookups
+// of contents.
+type object struct {
+	hash string
+	typ  string
+	size int64
+	data []byte
+}
+
+// Signature is the author or committer of a commit, with the time in the
+// time zone they recorded.
+type Signature struct {
+	Name  string
+	Email string
+	Time  time.Time
+}
+
+// commitObject is a parsed commit object.
+type commitObject struct {
+	tree      string
+	parents   []string
+	author    Signature
+	committer Signature
+	message   []byte
+}
+
+// subject is the first paragraph of the message joined into one line, as in
+// git's %s format.
+func (c *commitObject) subject() string {
+	msg := c.message
+	if i := bytes.Index(msg, []byte("\n\n")); i != -1 {
+		msg = msg[:i]
+	}
+	return strings.Join(strings.Fields(string(msg)), " ")
+}
+
+func parseCommit(data []byte) (*commitObject, error) {
+	c := &commitObject{}
+	header := data
+
+	if i := bytes.Index(data, []byte("\n\n")); i != -1 {
+		header, c.message = data[:i], data[i+2:]
+	}
+
+	for _, line := range bytes.Split(header, []byte{'\n'}) {
+		// continuation lines of multi-line headers such as gpgsig
+		if len(line) == 0 || line[0] == ' ' {
+			continue
+		}
+
+		key, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ' '); i != -1 {
+			key, value = line[:i], line[i+1:]
+		}
+
+		var err error
+
+		switch string(key) {
+		case "tree":
+			c.tree = string(value)
+		case "parent":
+			c.parents = append(c.parents, string(value))
+		case "author":
+			c.author, err = parseSignature(value)
+		case "committer":
+			c.committer, err = parseSignature(value)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.tree == "" {
+		return nil, errors.New("git: commit: missing tree")
+	}
+
+	return c, nil
+}
+
+func parseSignature(raw []byte) (sig Signature, err error) {
+	// "name <email> seconds zone"
+	i := bytes.LastIndexByte(raw, '<')
+	j := bytes.LastIndexByte(raw, '>')
+	if i == -1 || j < i {
+		return sig, errors.New("git: malformed signature")
+	}
+
+	sig.Name = string(bytes.TrimSpace(raw[:i]))
+	sig.Email = string(raw[i+1 : j])
+
+	// fields[0] = seconds since the epoch
+	// fields[1] = zone offset, such as -0700
+	fields := bytes.Fields(raw[j+1:])
+	if len(fields) != 2 || len(fields[1]) != 5 {
+		return sig, errors.New("git: malformed signature time")
+	}
+
+	sec, err := strconv.ParseInt(string(fields[0]), 10, 64)
+	if err != nil {
+		return
+	}
+
+	zone, err := strconv.Atoi(string(fields[1][1:]))
+	if err != nil {
+		return
+	}
+
+	offset := (zone/100)*3600 + (zone%100)*60
+	if fields[1][0] == '-' {
+		offset = -offset
+	}
+
+	sig.Time = time.Unix(sec, 0).In(time.FixedZone("", offset))
+	return
+}
+
+// Utility: find the object and type a tag points to
+func parseTag(data []byte) (hash, typ string, err error) {
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if len(line) == 0 {
+			break
+		}
+
+		switch {
+		case bytes.HasPrefix(line, []byte("object ")):
+			hash = string(line[len("object "):])
+		case bytes.HasPrefix(line, []byte("type ")):
+			typ = string(line[len("type "):])
+		}
+	}
+
+	if hash == "" || typ == "" {
+		err = errors.New("git: tag: missing object")
+	}
+	return
+}
+
+// treeEntry is an entry of a tree object.
+type treeEntry struct {
+	mode uint32
+	name string
+	hash string
+}
+
+// typ is the type of object the entry refers to, by its mode.
+func (e *treeEntry) typ() string {
+	switch e.mode & 0170000 {
+	case 0040000:
+		return "tree"
+	case 0160000:
+		return "commit"
+	default:
+		return "blob"
+	}
+}
+
+// fileMode converts the entry mode, which is octal with the object kind in
+// the high bits.
+func (e *treeEntry) fileMode() os.FileMode {
+	mode := os.FileMode(e.mode & 0777)
+
+	switch e.mode & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0160000:
+		mode |= os.ModeDir | os.ModeSymlink
+	}
+
+	return mode
+}
+
+func parseTree(data []byte, hashLen int) ([]treeEntry, error) {
+	var entries []treeEntry
+
+	// each entry is "mode name\x00" followed by the binary hash
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, ' ')
+		j := bytes.IndexByte(data, 0)
+		if i == -1 || j < i || len(data) < j+1+hashLen {
+			return nil, errors.New("git: tree: malformed entry")
+		}
+
+		mode, err := strconv.ParseUint(string(data[:i]), 8, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, treeEntry{
+			mode: uint32(mode),
+			name: string(data[i+1 : j]),
+			hash: hex.EncodeToString(data[j+1 : j+1+hashLen]),
+		})
+
+		data = data[j+1+hashLen:]
+	}
+
+	return entries, nil
+}
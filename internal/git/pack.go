@@ -0,0 +1,548 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Packed object types
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+var packTypes = map[int]string{
+	packCommit: "commit",
+	packTree:   "tree",
+	packBlob:   "blob",
+	packTag:    "tag",
+}
+
+// maxBases is how many bytes of delta bases are kept in memory, since
+// objects in a pack are mostly deltas against a few shared bases.
+const maxBases = 16 << 20
+
+// pack is a packfile and its version 2 index, which lists the hashes of the
+// objects in the pack sorted, and where each object begins in the pack.
+type pack struct {
+	path    string
+	size    int64
+	hashLen int
+	count   int
+	fanout  []byte
+	hashes  []byte
+	offsets []byte
+	large   []byte
+}
+
+// packOffset is where an object is in a pack.
+type packOffset struct {
+	pack   *pack
+	offset int64
+}
+
+// maxDepth bounds delta chains, which git limits to 4095 by default.
+const maxDepth = 10000
+
+var errMalformedPack = errors.New("git: malformed pack")
+
+// maxRatio is the most deflate expands data by, so no object is larger than
+// this many times the compressed data it is read from.
+const maxRatio = 1032
+
+// Utility: check the size of an object, before it is read, against the
+// compressed data it is read from and the size limit if positive
+func checkSize(size, stored, maxSize int64) error {
+	switch {
+	case size < 0 || size/maxRatio > stored:
+		return errors.New("git: malformed object size")
+	case maxSize > 0 && size > maxSize:
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// openPack reads the index of the pack at path, without the .idx or .pack
+// extension.
+func openPack(path string, hashLen int) (*pack, error) {
+	idx, err := ioutil.ReadFile(path + ".idx")
+	if err != nil {
+		return nil, err
+	}
+
+	// magic, version and 256 cumulative counts by first hash byte
+	const header = 8 + 256*4
+
+	if len(idx) < header || !bytes.Equal(idx[:8],
+		[]byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}) {
+		return nil, errors.New("git: unsupported pack index " + path)
+	}
+
+	fi, err := os.Stat(path + ".pack")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pack{
+		path:    path,
+		size:    fi.Size(),
+		hashLen: hashLen,
+		fanout:  idx[8:header],
+	}
+
+	// the counts never decrease, so every first byte's range of hashes is
+	// within the index
+	var prev uint32
+	for i := 0; i < 256; i++ {
+		n := binary.BigEndian.Uint32(p.fanout[i*4:])
+		if n < prev {
+			return nil, errMalformedPack
+		}
+		prev = n
+	}
+
+	p.count = int(prev)
+
+	// hashes, CRCs, 32-bit offsets, then 64-bit offsets for large packs,
+	// followed by the pack and index checksums
+	rest := idx[header:]
+	if len(rest) < p.count*(hashLen+8)+2*hashLen {
+		return nil, errMalformedPack
+	}
+
+	p.hashes = rest[:p.count*hashLen]
+	rest = rest[p.count*(hashLen+4):]
+	p.offsets = rest[:p.count*4]
+	p.large = rest[p.count*4 : len(rest)-2*hashLen]
+
+	return p, nil
+}
+
+// Utility: the hash at index i
+func (p *pack) hash(i int) []byte {
+	return p.hashes[i*p.hashLen : (i+1)*p.hashLen]
+}
+
+// Utility: find where an object begins in the pack
+func (p *pack) find(raw []byte) (int64, bool) {
+	lo, hi := 0, int(binary.BigEndian.Uint32(p.fanout[int(raw[0])*4:]))
+	if raw[0] > 0 {
+		lo = int(binary.BigEndian.Uint32(p.fanout[int(raw[0]-1)*4:]))
+	}
+
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.hash(lo+i), raw) >= 0
+	})
+
+	if i == hi || !bytes.Equal(p.hash(i), raw) {
+		return 0, false
+	}
+
+	off := int64(binary.BigEndian.Uint32(p.offsets[i*4:]))
+
+	// the high bit marks an index into the 64-bit offsets
+	if off&0x80000000 != 0 {
+		j := int(off&0x7fffffff) * 8
+		if j+8 > len(p.large) {
+			return 0, false
+		}
+		off = int64(binary.BigEndian.Uint64(p.large[j:]))
+	}
+
+	return off, true
+}
+
+// Utility: list the hashes beginning with a hex prefix
+func (p *pack) prefixed(prefix string) []string {
+	i := sort.Search(p.count, func(i int) bool {
+		return hex.EncodeToString(p.hash(i)) >= prefix
+	})
+
+	var ret []string
+
+	for ; i < p.count; i++ {
+		hash := hex.EncodeToString(p.hash(i))
+		if len(hash) < len(prefix) || hash[:len(prefix)] != prefix {
+			break
+		}
+		ret = append(ret, hash)
+	}
+
+	return ret
+}
+
+// packEntry is the header of an object in a pack.
+type packEntry struct {
+	typ  int
+	size int64
+
+	// the base of a delta, at an offset in the same pack or by hash
+	baseOffset int64
+	baseHash   []byte
+
+	// the compressed data, and at most how long it is
+	r      *bufio.Reader
+	stored int64
+}
+
+// Utility: read the header of the object at off, leaving the reader at the
+// start of its compressed data
+func (p *pack) entry(f *os.File, off int64) (*packEntry, error) {
+	r := bufio.NewReader(io.NewSectionReader(f, off, 1<<62))
+
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	// the type is in bits 4-6 of the first byte, and the size is a
+	// little-endian varint starting in its low 4 bits
+	e := &packEntry{
+		typ:    int(c>>4) & 7,
+		size:   int64(c & 15),
+		r:      r,
+		stored: p.size - off,
+	}
+
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+
+		// sizes fit in 63 bits
+		if shift > 56 {
+			return nil, errMalformedPack
+		}
+		e.size |= int64(c&0x7f) << shift
+	}
+
+	switch e.typ {
+	case packOfsDelta:
+		// a big-endian varint where each continuation also adds one,
+		// counting back from this object
+		if c, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			rel = (rel+1)<<7 | int64(c&0x7f)
+		}
+
+		if rel <= 0 || rel > off {
+			return nil, errMalformedPack
+		}
+		e.baseOffset = off - rel
+	case packRefDelta:
+		e.baseHash = make([]byte, p.hashLen)
+		if _, err = io.ReadFull(r, e.baseHash); err != nil {
+			return nil, err
+		}
+	case packCommit, packTree, packBlob, packTag:
+	default:
+		return nil, errMalformedPack
+	}
+
+	return e, nil
+}
+
+// Utility: decompress the data of an entry, up to n bytes if n is positive
+func (e *packEntry) inflate(n int64) ([]byte, error) {
+	z, err := zlib.NewReader(e.r)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+
+	if n <= 0 || n > e.size {
+		n = e.size
+	}
+
+	data := make([]byte, n)
+	if _, err = io.ReadFull(z, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Utility: read the object at off in a pack, applying deltas
+func (b *nativeBackend) readPacked(p *pack, off int64,
+	hash string) (*object, error) {
+	obj, err := b.resolvePacked(p, off, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &object{
+		hash: hash,
+		typ:  obj.typ,
+		size: obj.size,
+		data: obj.data,
+	}, nil
+}
+
+func (b *nativeBackend) resolvePacked(p *pack, off int64,
+	depth int) (*object, error) {
+	if depth > maxDepth {
+		return nil, errMalformedPack
+	}
+
+	key := packOffset{p, off}
+
+	b.mu.Lock()
+	obj, ok := b.bases[key]
+	b.mu.Unlock()
+
+	if ok {
+		return obj, nil
+	}
+
+	f, err := os.Open(p.path + ".pack")
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := p.entry(f, off)
+	if err == nil {
+		err = checkSize(e.size, e.stored, b.maxSize)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	data, err := e.inflate(0)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if typ, ok := packTypes[e.typ]; ok {
+		obj = &object{typ: typ, size: e.size, data: data}
+	} else {
+		var base *object
+
+		if e.typ == packOfsDelta {
+			base, err = b.resolvePacked(p, e.baseOffset, depth+1)
+		} else {
+			base, err = b.readBase(e.baseHash, depth+1)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if data, err = patchDelta(base.data, data, b.maxSize); err != nil {
+			return nil, err
+		}
+
+		obj = &object{typ: base.typ, size: int64(len(data)), data: data}
+	}
+
+	// objects are read again as the bases of other objects, so keep some
+	// in memory, starting over when full
+	if obj.size <= maxBases/16 {
+		b.mu.Lock()
+		if b.bases == nil || b.basesSize+obj.size > maxBases {
+			b.bases, b.basesSize = make(map[packOffset]*object), 0
+		}
+		b.bases[key] = obj
+		b.basesSize += obj.size
+		b.mu.Unlock()
+	}
+
+	return obj, nil
+}
+
+// Utility: read the base of a ref delta, which continues the delta chain if
+// it is packed
+func (b *nativeBackend) readBase(raw []byte, depth int) (*object, error) {
+	hash := hex.EncodeToString(raw)
+	if path, ok := b.loose(hash); ok {
+		return readLoose(hash, path, b.maxSize)
+	}
+
+	p, off, err := b.find(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.resolvePacked(p, off, depth)
+}
+
+// Utility: find the type and size of the object at off in a pack, which is
+// depth deltas into a chain
+func (b *nativeBackend) infoPacked(p *pack, off int64, depth int) (string,
+	int64, error) {
+	f, err := os.Open(p.path + ".pack")
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	var size int64 = -1
+
+	// the size is in the header of the outermost delta, the type in the
+	// header of the innermost base
+	for ; ; depth++ {
+		if depth > maxDepth {
+			return "", 0, errMalformedPack
+		}
+
+		e, err := p.entry(f, off)
+		if err != nil {
+			return "", 0, err
+		}
+
+		if typ, ok := packTypes[e.typ]; ok {
+			if size == -1 {
+				size = e.size
+			}
+			return typ, size, nil
+		}
+
+		if size == -1 {
+			// two varints, at most 10 bytes each
+			header, err := e.inflate(20)
+			if err != nil {
+				return "", 0, err
+			}
+
+			r := bytes.NewReader(header)
+			if _, err = binary.ReadUvarint(r); err == nil {
+				var n uint64
+				n, err = binary.ReadUvarint(r)
+				size = int64(n)
+			}
+			if err != nil {
+				return "", 0, errMalformedPack
+			}
+		}
+
+		if e.typ == packRefDelta {
+			typ, _, err := b.infoBase(e.baseHash, depth+1)
+			return typ, size, err
+		}
+
+		off = e.baseOffset
+	}
+}
+
+// Utility: find the type and size of the base of a ref delta, which continues
+// the delta chain if it is packed
+func (b *nativeBackend) infoBase(raw []byte, depth int) (string, int64,
+	error) {
+	if path, ok := b.loose(hex.EncodeToString(raw)); ok {
+		return infoLoose(path)
+	}
+
+	p, off, err := b.find(raw)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return b.infoPacked(p, off, depth)
+}
+
+// patchDelta applies a delta to its base, ErrTooLarge if the result would be
+// larger than maxSize if positive. The delta begins with the sizes of the base
+// and result, followed by instructions to copy from the base or insert new
+// data.
+func patchDelta(base, delta []byte, maxSize int64) ([]byte, error) {
+	r := bytes.NewReader(delta)
+
+	baseSize, err := binary.ReadUvarint(r)
+	if err != nil || baseSize != uint64(len(base)) {
+		return nil, errMalformedPack
+	}
+
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errMalformedPack
+	}
+
+	// every instruction is at least a byte, and copies at most 64 KiB
+	if size > uint64(len(delta))*0x10000 {
+		return nil, errMalformedPack
+	}
+
+	if maxSize > 0 && size > uint64(maxSize) {
+		return nil, ErrTooLarge
+	}
+
+	out := make([]byte, 0, size)
+
+	for {
+		op, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case op&0x80 != 0:
+			// the low 4 bits select which offset bytes follow and the
+			// next 3 bits which size bytes follow, little-endian
+			var off, n uint64
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+
+				c, err := r.ReadByte()
+				if err != nil {
+					return nil, errMalformedPack
+				}
+
+				if i < 4 {
+					off |= uint64(c) << (8 * i)
+				} else {
+					n |= uint64(c) << (8 * (i - 4))
+				}
+			}
+
+			if n == 0 {
+				n = 0x10000
+			}
+
+			if off+n > uint64(len(base)) ||
+				uint64(len(out))+n > size {
+				return nil, errMalformedPack
+			}
+			out = append(out, base[off:off+n]...)
+		case op != 0:
+			if uint64(len(out))+uint64(op) > size {
+				return nil, errMalformedPack
+			}
+
+			start := len(out)
+			out = append(out, make([]byte, op)...)
+			if _, err = io.ReadFull(r, out[start:]); err != nil {
+				return nil, errMalformedPack
+			}
+		default:
+			return nil, errMalformedPack
+		}
+	}
+
+	if uint64(len(out)) != size {
+		return nil, errMalformedPack
+	}
+
+	return out, nil
+}
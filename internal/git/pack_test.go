@@ -0,0 +1,192 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testEntry is an object written to a test pack, a ref delta if base is set.
+type testEntry struct {
+	hash string
+	typ  int
+	base string
+	data []byte
+}
+
+// Create a git directory without objects for the native backend, skipping
+// git so objects can be written by hand.
+func newTestGitDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "gitweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for _, name := range []string{"objects/pack", "refs/heads"} {
+		if err = os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	head := []byte("ref: refs/heads/main\n")
+	if err = ioutil.WriteFile(filepath.Join(dir, "HEAD"), head,
+		0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// Write a pack and its index of the entries to a git directory, returning
+// the path of the pack without its extension.
+func writeTestPack(t *testing.T, dir string, entries []testEntry) string {
+	t.Helper()
+
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(len(entries)))
+
+	offsets := make(map[string]uint32)
+
+	for _, e := range entries {
+		offsets[e.hash] = uint32(pack.Len())
+
+		typ := e.typ
+		if e.base != "" {
+			typ = packRefDelta
+		}
+
+		// the size continues from the low 4 bits of the type byte
+		size := len(e.data)
+		c := byte(typ<<4) | byte(size&15)
+		for size >>= 4; size > 0; size >>= 7 {
+			pack.WriteByte(c | 0x80)
+			c = byte(size & 0x7f)
+		}
+		pack.WriteByte(c)
+
+		if e.base != "" {
+			raw, _ := hex.DecodeString(e.base)
+			pack.Write(raw)
+		}
+
+		z := zlib.NewWriter(&pack)
+		z.Write(e.data)
+		z.Close()
+	}
+
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+
+	hashes := make([]string, 0, len(entries))
+	for _, e := range entries {
+		hashes = append(hashes, e.hash)
+	}
+	sort.Strings(hashes)
+
+	var fanout [256]uint32
+	for _, hash := range hashes {
+		raw, _ := hex.DecodeString(hash)
+		for i := int(raw[0]); i < 256; i++ {
+			fanout[i]++
+		}
+	}
+
+	var idx bytes.Buffer
+	idx.Write([]byte{0xff, 't', 'O', 'c', 0, 0, 0, 2})
+	binary.Write(&idx, binary.BigEndian, fanout)
+	for _, hash := range hashes {
+		raw, _ := hex.DecodeString(hash)
+		idx.Write(raw)
+	}
+	idx.Write(make([]byte, 4*len(hashes)))
+	for _, hash := range hashes {
+		binary.Write(&idx, binary.BigEndian, offsets[hash])
+	}
+	idx.Write(sum[:])
+	isum := sha1.Sum(idx.Bytes())
+	idx.Write(isum[:])
+
+	path := filepath.Join(dir, "objects", "pack",
+		"pack-"+hex.EncodeToString(sum[:]))
+	if err := ioutil.WriteFile(path+".pack", pack.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".idx", idx.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestPackDeltaCycle(t *testing.T) {
+	dir := newTestGitDir(t)
+
+	const (
+		a = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+		b = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	)
+
+	// each copies the whole of the other
+	delta := []byte{1, 1, 0x90, 1}
+
+	writeTestPack(t, dir, []testEntry{
+		{hash: a, base: b, data: delta},
+		{hash: b, base: a, data: delta},
+	})
+
+	nb, err := openNative(dir, time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = nb.read(a); err != errMalformedPack {
+		t.Errorf("read: got %v", err)
+	}
+
+	if _, _, err = nb.info(a); err != errMalformedPack {
+		t.Errorf("info: got %v", err)
+	}
+}
+
+func TestPackFanout(t *testing.T) {
+	dir := newTestGitDir(t)
+
+	path := writeTestPack(t, dir, []testEntry{
+		{hash: "0100000000000000000000000000000000000000", typ: packBlob,
+			data: []byte("a\n")},
+		{hash: "0200000000000000000000000000000000000000", typ: packBlob,
+			data: []byte("b\n")},
+	})
+
+	if _, err := openPack(path, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := ioutil.ReadFile(path + ".idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the count of hashes starting with 0x01 is larger than the total
+	binary.BigEndian.PutUint32(idx[8+1*4:], 100)
+	if err = ioutil.WriteFile(path+".idx", idx, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = openPack(path, 20); err != errMalformedPack {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Raw streams the unprocessed contents of a file. The contents are only read
+// once they are needed and from where they are needed, so seeking to find the
+// size or to the start of a range doesn't read the file.
+type Raw struct {
+	Hash string
+	Size int64
+
+	g      *Git
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// the open contents, at offset off, and the offset to read from
+	r   io.ReadCloser
+	off int64
+	pos int64
+}
+
+// Raw opens a tracked file at ref for streaming, whether binary or not. The
+// file must be read and closed within timeout, and if limit is positive it
+// must be at most limit bytes.
+func (g *Git) Raw(ref, file string, limit int64, timeout time.Duration) (*Raw,
+	error) {
+	if err := g.verify(ref); err != nil {
+		return nil, err
+	}
+
+	obj, err := g.db.lookup(ref+":"+file, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.typ != "blob" {
+		return nil, ErrNotExist
+	}
+
+	if limit > 0 && obj.size > limit {
+		return nil, ErrTooLarge
+	}
+
+	// the native backend reads objects whole
+	if g.native && g.maxSize > 0 && obj.size > g.maxSize {
+		return nil, ErrTooLarge
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	return &Raw{
+		Hash:   obj.hash,
+		Size:   obj.size,
+		g:      g,
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+func (raw *Raw) Read(p []byte) (int, error) {
+	if raw.pos >= raw.Size {
+		return 0, io.EOF
+	}
+
+	if raw.r == nil || raw.off != raw.pos {
+		if err := raw.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := raw.r.Read(p)
+	raw.off += int64(n)
+	raw.pos = raw.off
+
+	if err != nil && raw.ctx.Err() != nil {
+		err = raw.ctx.Err()
+	}
+	return n, err
+}
+
+// Utility: open the contents at the read offset, reusing the open contents
+// if they are before it
+func (raw *Raw) open() error {
+	if raw.r != nil && raw.off > raw.pos {
+		_ = raw.r.Close()
+		raw.r = nil
+	}
+
+	if raw.r == nil {
+		r, err := raw.g.db.stream(raw.ctx, raw.Hash)
+		if err != nil {
+			return err
+		}
+		raw.r, raw.off = r, 0
+	}
+
+	n, err := io.CopyN(ioutil.Discard, raw.r, raw.pos-raw.off)
+	raw.off += n
+	if err != nil && raw.ctx.Err() != nil {
+		err = raw.ctx.Err()
+	}
+	return err
+}
+
+// Seek sets the offset of the next Read, as io.Seeker.
+func (raw *Raw) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += raw.pos
+	case io.SeekEnd:
+		offset += raw.Size
+	default:
+		return 0, errors.New("git: raw: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("git: raw: negative offset")
+	}
+
+	raw.pos = offset
+	return offset, nil
+}
+
+// Close stops reading the file.
+func (raw *Raw) Close() error {
+	var err error
+	if raw.r != nil {
+		err = raw.r.Close()
+		raw.r = nil
+	}
+	raw.cancel()
+	return err
+}
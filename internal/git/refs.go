@@ -0,0 +1,115 @@
+package git
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ref types
+const (
+	RefBranch = iota
+	RefTag
+)
+
+// RefItem is a branch or tag. Annotated tags are
+// peeled, so the commit details are always of the tip commit.
+type RefItem struct {
+	Name    string
+	Type    int
+	Hash    string
+	Time    time.Time
+	Author  string
+	Subject string
+}
+
+// IsBranch reports whether the ref is a branch.
+func (item *RefItem) IsBranch() bool {
+	return item.Type == RefBranch
+}
+
+// IsTag reports whether the ref is a tag.
+func (item *RefItem) IsTag() bool {
+	return item.Type == RefTag
+}
+
+// Refs retrieves the branches and tags, most recent tip commit first.
+func (g *Git) Refs() ([]*RefItem, error) {
+	refs, err := g.db.refs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*RefItem
+
+	for _, ref := range refs {
+		item, err := g.refItem(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if item != nil {
+			ret = append(ret, item)
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Time.After(ret[j].Time)
+	})
+
+	return ret, nil
+}
+
+// Utility: describe the tip commit of a branch or tag, nil for other refs
+func (g *Git) refItem(ref refEntry) (*RefItem, error) {
+	item := &RefItem{}
+
+	switch {
+	case strings.HasPrefix(ref.name, "refs/heads/"):
+		item.Type = RefBranch
+		item.Name = strings.TrimPrefix(ref.name, "refs/heads/")
+	case strings.HasPrefix(ref.name, "refs/tags/"):
+		item.Type = RefTag
+		item.Name = strings.TrimPrefix(ref.name, "refs/tags/")
+	default:
+		return nil, nil
+	}
+
+	obj, err := g.db.lookup(ref.hash, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// annotated tags are peeled until they reach what they tag, which may
+	// be another tag
+	for obj.typ == "tag" {
+		hash, typ, err := parseTag(obj.data)
+		if err != nil {
+			return nil, err
+		}
+
+		// tags of trees or blobs have no commit to show
+		if typ != "commit" && typ != "tag" {
+			return nil, nil
+		}
+
+		if obj, err = g.db.lookup(hash, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if obj.typ != "commit" {
+		return nil, nil
+	}
+
+	c, err := parseCommit(obj.data)
+	if err != nil {
+		return nil, err
+	}
+
+	item.Hash = obj.hash
+	item.Time = c.author.Time
+	item.Author = c.author.Name
+	item.Subject = c.subject()
+	return item, nil
+}
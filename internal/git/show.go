@@ -10,20 +10,21 @@ type Show struct {
 
 // ErrNotExist is used in gitweb to determine if the request error was from a
 // bad request or happened running git.
-var ErrNotExist = errors.New("git: show: file does not exist")
+var ErrNotExist = errors.New("git: path does not exist")
 
-// Show retrieves the contents of a tracked file or mark as binary.
-func (g *Git) Show(file string) (show Show, err error) {
-	if !g.exists(file) {
-		err = ErrNotExist
+// Show retrieves the contents of a tracked file at ref or mark as binary.
+func (g *Git) Show(ref, file string) (show Show, err error) {
+	if err = g.verify(ref); err != nil {
 		return
 	}
 
-	if show.Binary = g.binary(file); show.Binary {
-		show.File = nil
+	obj, err := g.blob(ref, file)
+	if err != nil {
 		return
 	}
 
-	show.File, err = g.run("show", g.ref+":"+file)
+	if show.Binary = isBinary(obj.data); !show.Binary {
+		show.File = obj.data
+	}
 	return
 }
@@ -0,0 +1,43 @@
+package git
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"time"
+)
+
+var reProtocol = regexp.MustCompile("^[0-9A-Za-z=:._-]*$")
+
+// UploadPack serves a clone or fetch over the smart HTTP protocol, reading the
+// client request from r and streaming the response to w. If advertise is set
+// only the refs are advertised and r is unused. The protocol is the value of
+// the client's Git-Protocol header, if any.
+func (g *Git) UploadPack(w io.Writer, r io.Reader, advertise bool,
+	protocol string, timeout time.Duration) error {
+	if g.native {
+		return ErrUnsupported
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	arg := []string{"upload-pack", "--stateless-rpc"}
+	if advertise {
+		arg = append(arg, "--advertise-refs")
+	}
+
+	cmd := g.command(ctx, append(arg, ".")...)
+	cmd.Stdin = r
+	cmd.Stdout = w
+
+	if protocol != "" && reProtocol.MatchString(protocol) {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+protocol)
+	}
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = ctx.Err()
+	}
+	return err
+}
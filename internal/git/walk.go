@@ -0,0 +1,454 @@
+package git
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"time"
+)
+
+// log walks the history natively, newest commit first. Unlike git log it
+// doesn't follow renames, and only exact renames are detected in the stats.
+func (b *nativeBackend) log(hash, path string, file bool, skip,
+	count int) ([]*LogItem, error) {
+	deadline := time.Now().Add(b.timeout)
+
+	start, err := b.commit(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &walkQueue{}
+	seen := map[string]bool{hash: true}
+	heap.Push(q, &walkItem{hash: hash, commit: start})
+
+	var ret []*LogItem
+
+	for q.Len() > 0 && (count <= 0 || len(ret) < count) {
+		if time.Now().After(deadline) {
+			return nil, context.DeadlineExceeded
+		}
+
+		item := heap.Pop(q).(*walkItem)
+		c := item.commit
+
+		parents, include, err := b.simplify(c, path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range parents {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+
+			pc, err := b.commit(p)
+			if err != nil {
+				return nil, err
+			}
+
+			q.seq++
+			heap.Push(q, &walkItem{hash: p, commit: pc, seq: q.seq})
+		}
+
+		if !include {
+			continue
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		log := &LogItem{
+			Time:    c.author.Time,
+			Hash:    item.hash,
+			Name:    c.author.Name,
+			Subject: c.subject(),
+		}
+
+		// like git log, merges have no stats
+		if len(c.parents) <= 1 {
+			var parent string
+			if len(c.parents) == 1 {
+				pc, err := b.commit(c.parents[0])
+				if err != nil {
+					return nil, err
+				}
+				parent = pc.tree
+			}
+
+			log.Stat, err = b.diffStat(parent, c.tree, path, deadline)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ret = append(ret, log)
+	}
+
+	return ret, nil
+}
+
+// Utility: choose which parents of a commit to walk and whether to show it
+// when the history is restricted to path. As in git's default history
+// simplification, a commit with the same path as one of its parents is hidden
+// and only that parent is walked.
+func (b *nativeBackend) simplify(c *commitObject, path string) ([]string,
+	bool, error) {
+	if path == "" {
+		return c.parents, true, nil
+	}
+
+	entry, err := b.pathHash(c.tree, path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(c.parents) == 0 {
+		return nil, entry != "", nil
+	}
+
+	for _, p := range c.parents {
+		pc, err := b.commit(p)
+		if err != nil {
+			return nil, false, err
+		}
+
+		pentry, err := b.pathHash(pc.tree, path)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if pentry == entry {
+			return []string{p}, false, nil
+		}
+	}
+
+	return c.parents, true, nil
+}
+
+// Utility: the hash of the object at path in a tree, empty if missing
+func (b *nativeBackend) pathHash(tree, path string) (string, error) {
+	hash, _, err := b.treePath(tree, path)
+	if err == ErrNotExist {
+		return "", nil
+	}
+	return hash, err
+}
+
+// fileChange is a changed file between two trees, either side of which has
+// an empty hash if the file was added or deleted.
+type fileChange struct {
+	old, new treeEntry
+}
+
+// Utility: count the changed files and lines between two trees, either of
+// which may be empty, restricted to path if not empty, by deadline
+func (b *nativeBackend) diffStat(old, new, path string,
+	deadline time.Time) (stat LogStat, err error) {
+	oldEntry := treeEntry{mode: 0040000, hash: old}
+	newEntry := treeEntry{mode: 0040000, hash: new}
+
+	if path != "" {
+		if oldEntry, err = b.pathEntry(old, path); err != nil {
+			return
+		}
+
+		if newEntry, err = b.pathEntry(new, path); err != nil {
+			return
+		}
+	}
+
+	var changes []fileChange
+	if err = b.diffEntries(oldEntry, newEntry, &changes); err != nil {
+		return
+	}
+
+	// exact renames count once, without changed lines
+	added := make(map[string][]int)
+	for i, c := range changes {
+		if c.old.hash == "" {
+			added[c.new.hash] = append(added[c.new.hash], i)
+		}
+	}
+
+	renamed := make(map[int]bool)
+	for i, c := range changes {
+		if c.new.hash != "" || len(added[c.old.hash]) == 0 {
+			continue
+		}
+
+		j := added[c.old.hash][0]
+		added[c.old.hash] = added[c.old.hash][1:]
+		renamed[i], renamed[j] = true, true
+		stat.Changed++
+	}
+
+	for i, c := range changes {
+		if renamed[i] {
+			continue
+		}
+
+		stat.Changed++
+
+		ins, del, err := b.diffLines(c.old, c.new, deadline)
+		if err != nil {
+			return stat, err
+		}
+
+		stat.Insertions += ins
+		stat.Deletions += del
+	}
+
+	return
+}
+
+// Utility: the entry at path in a tree, with an empty hash if either is
+// missing
+func (b *nativeBackend) pathEntry(tree, path string) (e treeEntry,
+	err error) {
+	if tree == "" {
+		return
+	}
+
+	e.hash, e.mode, err = b.treePath(tree, path)
+	if err == ErrNotExist {
+		e, err = treeEntry{}, nil
+	}
+	return
+}
+
+// Utility: collect the changed files between two entries, each of which may
+// be a tree, a file or missing
+func (b *nativeBackend) diffEntries(old, new treeEntry,
+	changes *[]fileChange) error {
+	if old.hash == new.hash && old.mode == new.mode {
+		return nil
+	}
+
+	oldTree := old.hash != "" && old.typ() == "tree"
+	newTree := new.hash != "" && new.typ() == "tree"
+
+	if !oldTree && !newTree {
+		*changes = append(*changes, fileChange{old: old, new: new})
+		return nil
+	}
+
+	var oldEntries, newEntries []treeEntry
+	var err error
+
+	if oldTree {
+		if oldEntries, err = b.tree(old.hash); err != nil {
+			return err
+		}
+	} else if old.hash != "" {
+		*changes = append(*changes, fileChange{old: old})
+	}
+
+	if newTree {
+		if newEntries, err = b.tree(new.hash); err != nil {
+			return err
+		}
+	} else if new.hash != "" {
+		*changes = append(*changes, fileChange{new: new})
+	}
+
+	added := make(map[string]treeEntry, len(newEntries))
+	for _, e := range newEntries {
+		added[e.name] = e
+	}
+
+	for _, e := range oldEntries {
+		n := added[e.name]
+		delete(added, e.name)
+
+		if err = b.diffEntries(e, n, changes); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range newEntries {
+		if _, ok := added[e.name]; ok {
+			if err = b.diffEntries(treeEntry{}, e, changes); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Utility: read and parse a tree
+func (b *nativeBackend) tree(hash string) ([]treeEntry, error) {
+	obj, err := b.read(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.typ != "tree" {
+		return nil, ErrNotExist
+	}
+
+	return parseTree(obj.data, b.hashLen)
+}
+
+// Utility: count the inserted and deleted lines between two files by
+// deadline, either of which may be missing, and which have no changed lines if
+// binary or too large to read
+func (b *nativeBackend) diffLines(old, new treeEntry,
+	deadline time.Time) (uint64, uint64, error) {
+	var data [2][]byte
+
+	for i, e := range []treeEntry{old, new} {
+		switch {
+		case e.hash == "":
+		case e.typ() == "commit":
+			// submodules are diffed as their commit
+			data[i] = []byte("Subproject commit " + e.hash + "\n")
+		default:
+			obj, err := b.read(e.hash)
+			if err == ErrTooLarge {
+				// counted like binary files
+				return 0, 0, nil
+			} else if err != nil {
+				return 0, 0, err
+			}
+			data[i] = obj.data
+		}
+
+		if isBinary(data[i]) {
+			return 0, 0, nil
+		}
+	}
+
+	a, c := splitLines(data[0], data[1])
+	d, err := editDistance(a, c, deadline)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// the edit script deletes the old lines not kept and inserts the new
+	// lines not kept, so the counts follow from its length
+	kept := (len(a) + len(c) - d) / 2
+	return uint64(len(c) - kept), uint64(len(a) - kept), nil
+}
+
+// Utility: split two files into lines, numbering equal lines the same
+func splitLines(a, b []byte) ([]int, []int) {
+	ids := make(map[string]int)
+
+	split := func(data []byte) []int {
+		var ret []int
+		for len(data) > 0 {
+			i := bytes.IndexByte(data, '\n') + 1
+			if i == 0 {
+				i = len(data)
+			}
+
+			line := string(data[:i])
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+
+			ret = append(ret, id)
+			data = data[i:]
+		}
+		return ret
+	}
+
+	return split(a), split(b)
+}
+
+// editDistance is the length of the shortest edit script of insertions and
+// deletions between a and b, from Myers' "An O(ND) Difference Algorithm and
+// Its Variations". It takes time proportional to the length times the
+// distance, so it gives up at deadline.
+func editDistance(a, b []int, deadline time.Time) (int, error) {
+	// common prefixes and suffixes are never edited
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		a, b = a[1:], b[1:]
+	}
+
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	n, m := len(a), len(b)
+	max := n + m
+	if n == 0 || m == 0 {
+		return max, nil
+	}
+
+	// v[max+k] is the furthest x reached on diagonal k = x - y
+	v := make([]int, 2*max+2)
+
+	for d := 0; d <= max; d++ {
+		if time.Now().After(deadline) {
+			return 0, context.DeadlineExceeded
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[max+k-1] < v[max+k+1] {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+
+			v[max+k] = x
+
+			if x >= n && y >= m {
+				return d, nil
+			}
+		}
+	}
+
+	return max, nil
+}
+
+// walkItem is a commit waiting to be walked.
+type walkItem struct {
+	hash   string
+	commit *commitObject
+	seq    int
+}
+
+// walkQueue orders commits by commit time, newest first, and otherwise in the
+// order they were found.
+type walkQueue struct {
+	items []*walkItem
+	seq   int
+}
+
+func (q *walkQueue) Len() int { return len(q.items) }
+
+func (q *walkQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if !a.commit.committer.Time.Equal(b.commit.committer.Time) {
+		return a.commit.committer.Time.After(b.commit.committer.Time)
+	}
+	return a.seq < b.seq
+}
+
+func (q *walkQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+}
+
+func (q *walkQueue) Push(x interface{}) {
+	q.items = append(q.items, x.(*walkItem))
+}
+
+func (q *walkQueue) Pop() interface{} {
+	item := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return item
+}
@@ -0,0 +1,45 @@
+package git
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		d    int
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"abc", "abd", 2},
+		{"abcabba", "cbabac", 5},
+		{"xaby", "xy", 2},
+	}
+
+	ids := func(s string) []int {
+		ret := make([]int, len(s))
+		for i := range s {
+			ret[i] = int(s[i])
+		}
+		return ret
+	}
+
+	deadline := time.Now().Add(time.Minute)
+
+	for _, test := range tests {
+		d, err := editDistance(ids(test.a), ids(test.b), deadline)
+		if err != nil || d != test.d {
+			t.Errorf("%q, %q: got %d, %v, want %d", test.a, test.b, d,
+				err, test.d)
+		}
+	}
+
+	_, err := editDistance(ids("abc"), ids("xyz"), time.Now())
+	if err != context.DeadlineExceeded {
+		t.Errorf("past deadline: got %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package highlight
+
+import (
+	"path"
+	"strings"
+)
+
+// Token classes
+const (
+	Plain   = ""
+	Keyword = "keyword"
+	String  = "string"
+	Comment = "comment"
+	Number  = "number"
+)
+
+// Token is a run of source code of one class.
+type Token struct {
+	Class string
+	Text  string
+}
+
+// maxSize is the largest file which is highlighted, as every token becomes an
+// element of the page.
+const maxSize = 1 << 20
+
+// Highlight splits a file into tokens, choosing the language by the file
+// name or a shebang line. It returns nil if the language isn't known or the
+// file is too large.
+func Highlight(name string, data []byte) []Token {
+	if len(data) > maxSize {
+		return nil
+	}
+
+	l := detect(name, data)
+	if l == nil {
+		return nil
+	}
+
+	return l.tokenize(string(data))
+}
+
+// Utility: choose the language of a file
+func detect(name string, data []byte) *language {
+	base := path.Base(name)
+
+	if l, ok := names[base]; ok {
+		return l
+	}
+
+	if l, ok := extensions[strings.ToLower(path.Ext(base))]; ok {
+		return l
+	}
+
+	if len(data) < 2 || data[0] != '#' || data[1] != '!' {
+		return nil
+	}
+
+	line := string(data)
+	if i := strings.IndexByte(line, '\n'); i != -1 {
+		line = line[:i]
+	}
+
+	// "#!/usr/bin/env -S python3 -u" runs the first argument
+	fields := strings.Fields(line[2:])
+	for len(fields) > 0 && (path.Base(fields[0]) == "env" ||
+		strings.HasPrefix(fields[0], "-")) {
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// versioned interpreters, such as python3.11
+	interp := strings.TrimRight(path.Base(fields[0]), "0123456789.")
+	return interpreters[interp]
+}
+
+// language describes the syntax of a language closely enough to class its
+// tokens.
+type language struct {
+	// line comments, which only begin words if spaced, as in shell
+	comments []string
+	spaced   bool
+
+	// block comments, as opening and closing delimiters
+	blocks [][2]string
+
+	// string delimiters, either with backslash escapes or raw, longest
+	// first where one is a prefix of another
+	quotes []string
+	raw    []string
+
+	// whether escaped strings may span lines, otherwise strings with
+	// single-character delimiters end at the end of the line
+	multiline bool
+
+	keywords map[string]bool
+	fold     bool
+}
+
+// Utility: split source code into tokens, merging runs of the same class
+func (l *language) tokenize(src string) []Token {
+	var tokens []Token
+	var prev byte
+
+	start, class := 0, Plain
+
+	for i := 0; i < len(src); {
+		c, n := l.next(src[i:], prev)
+
+		if c != class {
+			if i > start {
+				tokens = append(tokens, Token{Class: class, Text: src[start:i]})
+			}
+			start, class = i, c
+		}
+
+		i += n
+		prev = src[i-1]
+	}
+
+	if start < len(src) {
+		tokens = append(tokens, Token{Class: class, Text: src[start:]})
+	}
+
+	return tokens
+}
+
+// Utility: the class and length of the token at the start of src, where prev
+// is the byte before it. Block comments are tried before line comments, which
+// may be their prefix.
+func (l *language) next(src string, prev byte) (string, int) {
+	for _, b := range l.blocks {
+		if !strings.HasPrefix(src, b[0]) {
+			continue
+		}
+
+		if i := strings.Index(src[len(b[0]):], b[1]); i != -1 {
+			return Comment, len(b[0]) + i + len(b[1])
+		}
+		return Comment, len(src)
+	}
+
+	for _, c := range l.comments {
+		if !strings.HasPrefix(src, c) || l.spaced && !isSpace(prev) {
+			continue
+		}
+
+		if i := strings.IndexByte(src, '\n'); i != -1 {
+			return Comment, i
+		}
+		return Comment, len(src)
+	}
+
+	for _, q := range l.raw {
+		if !strings.HasPrefix(src, q) {
+			continue
+		}
+
+		if i := strings.Index(src[len(q):], q); i != -1 {
+			return String, len(q) + i + len(q)
+		}
+		return String, len(src)
+	}
+
+	for _, q := range l.quotes {
+		if strings.HasPrefix(src, q) {
+			return String, l.quoted(src, q)
+		}
+	}
+
+	c := src[0]
+
+	if isWord(prev) || !isWord(c) {
+		return Plain, 1
+	}
+
+	n := 1
+	for n < len(src) && (isWord(src[n]) || c >= '0' && c <= '9' &&
+		src[n] == '.') {
+		n++
+	}
+
+	if c >= '0' && c <= '9' {
+		return Number, n
+	}
+
+	word := src[:n]
+	if l.fold {
+		word = strings.ToLower(word)
+	}
+
+	if l.keywords[word] {
+		return Keyword, n
+	}
+	return Plain, n
+}
+
+// Utility: the length of the escaped string at the start of src
+func (l *language) quoted(src, q string) int {
+	for i := len(q); i < len(src); i++ {
+		switch {
+		case src[i] == '\\':
+			i++
+		case strings.HasPrefix(src[i:], q):
+			return i + len(q)
+		case src[i] == '\n' && !l.multiline && len(q) == 1:
+			return i
+		}
+	}
+	return len(src)
+}
+
+func isSpace(c byte) bool {
+	return c == 0 || c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isWord(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' || c == '_'
+}
+
+// Lines splits tokens into lines without their newlines, splitting tokens
+// which span lines, such as block comments. A final newline doesn't begin
+// another line.
+func Lines(tokens []Token) [][]Token {
+	var lines [][]Token
+	var line []Token
+
+	for _, t := range tokens {
+		for {
+			i := strings.IndexByte(t.Text, '\n')
+			if i == -1 {
+				break
+			}
+
+			if i > 0 {
+				line = append(line, Token{Class: t.Class, Text: t.Text[:i]})
+			}
+
+			lines = append(lines, line)
+			line = nil
+			t.Text = t.Text[i+1:]
+		}
+
+		if t.Text != "" {
+			line = append(line, t)
+		}
+	}
+
+	if line != nil {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
@@ -0,0 +1,263 @@
+package highlight
+
+import "strings"
+
+// Utility: a set of space-separated keywords
+func words(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+// cBlock is the block comment of C and the languages which follow it.
+var cBlock = [][2]string{{"/*", "*/"}}
+
+var goLang = &language{
+	comments: []string{"//"},
+	blocks:   cBlock,
+	quotes:   []string{`"`, `'`},
+	raw:      []string{"`"},
+	keywords: words(`break case chan const continue default defer else
+		fallthrough for func go goto if import interface map package range
+		return select struct switch type var true false nil iota`),
+}
+
+var cLang = &language{
+	comments: []string{"//"},
+	blocks:   cBlock,
+	quotes:   []string{`"`, `'`},
+	keywords: words(`auto break case char const continue default do double
+		else enum extern float for goto if inline int long register restrict
+		return short signed sizeof static struct switch typedef union
+		unsigned void volatile while bool true false NULL`),
+}
+
+var cppLang = &language{
+	comments: []string{"//"},
+	blocks:   cBlock,
+	quotes:   []string{`"`, `'`},
+	keywords: words(`alignas alignof auto bool break case catch char class
+		const constexpr const_cast continue decltype default delete do double
+		dynamic_cast else enum explicit export extern false float for friend
+		goto if inline int long mutable namespace new noexcept nullptr
+		operator override private protected public register
+		reinterpret_cast return short signed sizeof static static_assert
+		static_cast struct switch template this throw true try typedef
+		typename union unsigned using virtual void volatile while`),
+}
+
+var javaLang = &language{
+	comments: []string{"//"},
+	blocks:   cBlock,
+	quotes:   []string{`"""`, `"`, `'`},
+	keywords: words(`abstract assert boolean break byte case catch char class
+		const continue default do double else enum extends final finally
+		float for goto if implements import instanceof int interface long
+		native new package private protected public record return short
+		static strictfp super switch synchronized this throw throws
+		transient try var void volatile while true false null`),
+}
+
+var jsLang = &language{
+	comments: []string{"//"},
+	blocks:   cBlock,
+	quotes:   []string{`"`, `'`},
+	raw:      []string{"`"},
+	keywords: words(`async await break case catch class const continue
+		debugger default delete do else export extends finally for from
+		function if import in instanceof let new of return static super
+		switch this throw try typeof var void while with yield true false
+		null undefined`),
+}
+
+var tsLang = &language{
+	comments: []string{"//"},
+	blocks:   cBlock,
+	quotes:   []string{`"`, `'`},
+	raw:      []string{"`"},
+	keywords: words(`abstract any as async await boolean break case catch
+		class const continue debugger declare default delete do else enum
+		export extends finally for from function if implements import in
+		instanceof interface keyof let namespace never new number of private
+		protected public readonly return static string super switch this
+		throw try type typeof unknown var void while yield true false null
+		undefined`),
+}
+
+// Rust has no single-quoted strings, as lifetimes begin with a quote.
+var rustLang = &language{
+	comments: []string{"//"},
+	blocks:   cBlock,
+	quotes:   []string{`"`},
+	keywords: words(`as async await break const continue crate dyn else enum
+		extern false fn for if impl in let loop match mod move mut pub ref
+		return self Self static struct super trait true type unsafe use
+		where while`),
+}
+
+var pythonLang = &language{
+	comments: []string{"#"},
+	quotes:   []string{`"""`, `'''`, `"`, `'`},
+	keywords: words(`and as assert async await break class continue def del
+		elif else except finally for from global if import in is lambda
+		nonlocal not or pass raise return try while with yield True False
+		None`),
+}
+
+var shellLang = &language{
+	comments:  []string{"#"},
+	spaced:    true,
+	quotes:    []string{`"`},
+	raw:       []string{`'`},
+	multiline: true,
+	keywords: words(`case do done elif else esac exit export fi for function
+		if in local readonly return select set shift then unset until
+		while`),
+}
+
+var rubyLang = &language{
+	comments:  []string{"#"},
+	blocks:    [][2]string{{"=begin", "=end"}},
+	quotes:    []string{`"`, `'`},
+	multiline: true,
+	keywords: words(`alias and begin break case class def defined do else
+		elsif end ensure false for if in module next nil not or redo rescue
+		retry return self super then true undef unless until when while
+		yield`),
+}
+
+var perlLang = &language{
+	comments:  []string{"#"},
+	quotes:    []string{`"`, `'`},
+	multiline: true,
+	keywords: words(`else elsif for foreach if last local my next our
+		package return sub unless until use while`),
+}
+
+var luaLang = &language{
+	comments: []string{"--"},
+	blocks:   [][2]string{{"--[[", "]]"}},
+	quotes:   []string{`"`, `'`},
+	keywords: words(`and break do else elseif end false for function goto if
+		in local nil not or repeat return then true until while`),
+}
+
+// SQL keywords are case-insensitive.
+var sqlLang = &language{
+	comments:  []string{"--"},
+	blocks:    cBlock,
+	quotes:    []string{`'`, `"`},
+	multiline: true,
+	fold:      true,
+	keywords: words(`add all alter and as asc begin between by case check
+		column commit constraint create cross default delete desc distinct
+		drop else end exists foreign from full group having if in index
+		inner insert into is join key left like limit not null on or order
+		outer primary references right rollback select set table then
+		transaction union unique update values view when where with`),
+}
+
+var makeLang = &language{
+	comments: []string{"#"},
+	spaced:   true,
+	keywords: words(`define else endef endif export ifdef ifeq ifndef ifneq
+		include override unexport vpath`),
+}
+
+var dockerLang = &language{
+	comments:  []string{"#"},
+	spaced:    true,
+	quotes:    []string{`"`},
+	multiline: true,
+	fold:      true,
+	keywords: words(`add arg cmd copy entrypoint env expose from healthcheck
+		label maintainer onbuild run shell stopsignal user volume workdir`),
+}
+
+var yamlLang = &language{
+	comments: []string{"#"},
+	spaced:   true,
+	quotes:   []string{`"`},
+	raw:      []string{`'`},
+	keywords: words(`true false null yes no on off`),
+}
+
+var tomlLang = &language{
+	comments: []string{"#"},
+	spaced:   true,
+	quotes:   []string{`"""`, `"`},
+	raw:      []string{`'''`, `'`},
+	keywords: words(`true false`),
+}
+
+var jsonLang = &language{
+	quotes:   []string{`"`},
+	keywords: words(`true false null`),
+}
+
+var cssLang = &language{
+	blocks:   cBlock,
+	quotes:   []string{`"`, `'`},
+	keywords: words(`important inherit initial unset none auto`),
+}
+
+var extensions = map[string]*language{
+	".go":         goLang,
+	".c":          cLang,
+	".h":          cLang,
+	".cc":         cppLang,
+	".cpp":        cppLang,
+	".cxx":        cppLang,
+	".hh":         cppLang,
+	".hpp":        cppLang,
+	".java":       javaLang,
+	".js":         jsLang,
+	".mjs":        jsLang,
+	".cjs":        jsLang,
+	".jsx":        jsLang,
+	".ts":         tsLang,
+	".tsx":        tsLang,
+	".rs":         rustLang,
+	".py":         pythonLang,
+	".sh":         shellLang,
+	".bash":       shellLang,
+	".ksh":        shellLang,
+	".zsh":        shellLang,
+	".rb":         rubyLang,
+	".pl":         perlLang,
+	".pm":         perlLang,
+	".lua":        luaLang,
+	".sql":        sqlLang,
+	".mk":         makeLang,
+	".dockerfile": dockerLang,
+	".yml":        yamlLang,
+	".yaml":       yamlLang,
+	".toml":       tomlLang,
+	".json":       jsonLang,
+	".css":        cssLang,
+}
+
+var names = map[string]*language{
+	"Makefile":    makeLang,
+	"GNUmakefile": makeLang,
+	"makefile":    makeLang,
+	"Dockerfile":  dockerLang,
+	"Gemfile":     rubyLang,
+	"Rakefile":    rubyLang,
+}
+
+var interpreters = map[string]*language{
+	"sh":     shellLang,
+	"ash":    shellLang,
+	"bash":   shellLang,
+	"dash":   shellLang,
+	"ksh":    shellLang,
+	"zsh":    shellLang,
+	"python": pythonLang,
+	"ruby":   rubyLang,
+	"perl":   perlLang,
+	"node":   jsLang,
+	"lua":    luaLang,
+}
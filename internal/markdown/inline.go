@@ -0,0 +1,485 @@
+package markdown
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+var (
+	reScheme   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:`)
+	reAutolink = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^\s<>]*|[^\s<>@]+@[^\s<>@]+\.[^\s<>@]+)>`)
+	reBareURL  = regexp.MustCompile(`^https?://[^\s<]*[^\s<.,:;!?'")\]*_~]`)
+)
+
+// safeSchemes are the schemes links may use, other than relative links.
+var safeSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+	"ftp":    true,
+}
+
+// Utility: report whether a link destination is relative or uses a safe
+// scheme, as browsers will read it after decoding entities and ignoring
+// whitespace and control characters
+func safeURL(dest string) bool {
+	dest = strings.Map(func(c rune) rune {
+		if c <= ' ' || c == 0x7f {
+			return -1
+		}
+		return c
+	}, html.UnescapeString(dest))
+
+	scheme := reScheme.FindString(dest)
+	return scheme == "" || safeSchemes[strings.ToLower(scheme[:len(scheme)-1])]
+}
+
+// Utility: report whether a link destination is relative to the document,
+// rather than absolute or a fragment of it
+func isRelative(dest string) bool {
+	return dest != "" && dest[0] != '#' && !strings.HasPrefix(dest, "//") &&
+		!reScheme.MatchString(dest)
+}
+
+// Utility: escape text, decoding entities first so they aren't escaped twice
+func (r *renderer) text(s string) {
+	r.b.WriteString(template.HTMLEscapeString(html.UnescapeString(s)))
+}
+
+// Utility: render inline Markdown, without links inside links
+func (r *renderer) inline(s string, inLink bool) {
+	// deeply nested spans are left as text
+	if r.depth++; r.depth > maxDepth {
+		r.text(s)
+		r.depth--
+		return
+	}
+	defer func() { r.depth-- }()
+
+	start := 0
+
+	// delimiters known to have no closer in the rest of s, so unmatched
+	// openers don't each scan to the end
+	failed := make(map[string]bool)
+
+	// flush the text before i
+	flush := func(i int) {
+		if i > start {
+			r.text(s[start:i])
+		}
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			flush(i)
+			r.b.WriteString("<br>\n")
+			i += 2
+			start = i
+			continue
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			flush(i)
+			r.text(s[i+1 : i+2])
+			i += 2
+			start = i
+			continue
+		case c == '\n':
+			// two spaces before a newline are a hard break
+			if strings.HasSuffix(s[start:i], "  ") {
+				flush(len(strings.TrimRight(s[:i], " ")))
+				r.b.WriteString("<br>\n")
+				i++
+				start = i
+				continue
+			}
+		case c == '`':
+			if n := r.codeSpan(s[i:], flush, i, failed); n > 0 {
+				i += n
+				start = i
+				continue
+			}
+		case c == '<' && !inLink:
+			if m := reAutolink.FindStringSubmatch(s[i:]); m != nil {
+				dest := m[1]
+				if !reScheme.MatchString(dest) {
+					dest = "mailto:" + dest
+				}
+
+				if safeURL(dest) {
+					flush(i)
+					r.link(dest, "", m[1], false)
+					i += len(m[0])
+					start = i
+					continue
+				}
+			}
+		case c == 'h' && !inLink && (i == 0 || !isWord(s[i-1])):
+			if m := reBareURL.FindString(s[i:]); m != "" {
+				flush(i)
+				r.link(m, "", m, false)
+				i += len(m)
+				start = i
+				continue
+			}
+		case c == '[' || c == '!' && i+1 < len(s) && s[i+1] == '[':
+			if n := r.linkSpan(s, i, flush, inLink, failed); n > 0 {
+				i += n
+				start = i
+				continue
+			}
+		case c == '*' || c == '_' || c == '~':
+			if n := r.emphasis(s, i, flush, inLink, failed); n > 0 {
+				i += n
+				start = i
+				continue
+			}
+		}
+
+		i++
+	}
+
+	flush(len(s))
+}
+
+// Utility: render a code span at the start of s, returning its length or
+// zero if the backticks aren't closed
+func (r *renderer) codeSpan(s string, flush func(int), at int,
+	failed map[string]bool) int {
+	n := len(s) - len(strings.TrimLeft(s, "`"))
+	fence := s[:n]
+
+	if failed[fence] {
+		return 0
+	}
+
+	for j := n; j < len(s); {
+		k := strings.Index(s[j:], fence)
+		if k == -1 {
+			break
+		}
+		k += j
+
+		// the closing run must be exactly as long
+		end := k + n
+		if end < len(s) && s[end] == '`' {
+			j = end + len(s[end:]) - len(strings.TrimLeft(s[end:], "`"))
+			continue
+		}
+
+		code := strings.Replace(s[n:k], "\n", " ", -1)
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' &&
+			strings.Trim(code, " ") != "" {
+			code = code[1 : len(code)-1]
+		}
+
+		flush(at)
+		r.b.WriteString("<code>" + template.HTMLEscapeString(code) +
+			"</code>")
+		return end
+	}
+
+	failed[fence] = true
+	return 0
+}
+
+// Utility: render a link or image at s[i:], returning its length or zero if
+// it isn't one
+func (r *renderer) linkSpan(s string, i int, flush func(int), inLink bool,
+	failed map[string]bool) int {
+	image := s[i] == '!'
+	open := i
+	if image {
+		open++
+	}
+
+	closing := matchBracket(s, open)
+	if closing == -1 {
+		return 0
+	}
+
+	label := s[open+1 : closing]
+	end := closing + 1
+
+	var ref reference
+	found := false
+
+	switch {
+	case end < len(s) && s[end] == '(':
+		if n, dest, title, ok := parseDestination(s[end:], failed); ok {
+			ref, found = reference{dest: dest, title: title}, true
+			end += n
+		}
+	case end < len(s) && s[end] == '[':
+		if k := indexByte(s[end:], ']', failed); k != -1 {
+			name := s[end+1 : end+k]
+			if name == "" {
+				name = label
+			}
+			ref, found = r.refs[normalizeLabel(name)]
+			end += k + 1
+		}
+	default:
+		ref, found = r.refs[normalizeLabel(label)]
+	}
+
+	if !found || !safeURL(ref.dest) {
+		return 0
+	}
+
+	dest := ref.dest
+	if r.rewrite != nil && isRelative(dest) {
+		dest = r.rewrite(html.UnescapeString(dest))
+	}
+
+	flush(i)
+
+	// images can't be shown, and links can't be nested, so both fall back
+	// to their text
+	if inLink {
+		r.inline(label, true)
+	} else {
+		r.link(dest, ref.title, label, true)
+	}
+
+	return end - i
+}
+
+// maxLabel bounds how far the bracket closing a link's text is looked for.
+const maxLabel = 1000
+
+// Utility: the index of the bracket closing the one at s[open], or -1
+func matchBracket(s string, open int) int {
+	depth := 0
+
+	for j := open; j < len(s) && j-open <= maxLabel; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			// brackets in code spans don't count
+			if k := strings.IndexByte(s[j+1:], '`'); k != -1 {
+				j += k + 1
+			}
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				return j
+			}
+		}
+	}
+
+	return -1
+}
+
+// maxParens bounds the nesting of parentheses in a link destination.
+const maxParens = 32
+
+// Utility: parse `(dest "title")` at the start of s, returning its length
+func parseDestination(s string, failed map[string]bool) (int, string, string,
+	bool) {
+	j := 1
+	for j < len(s) && s[j] == ' ' {
+		j++
+	}
+
+	var dest string
+
+	if j < len(s) && s[j] == '<' {
+		k := indexByte(s[j:], '>', failed)
+		if k == -1 {
+			return 0, "", "", false
+		}
+		dest = s[j+1 : j+k]
+		j += k + 1
+	} else {
+		// parentheses in the destination must be balanced, and are
+		// nested at most maxParens deep. Every link opens one, so the
+		// limit also bounds how many later links each destination is
+		// scanned past, keeping unclosed links linear.
+		depth, k := 0, j
+	loop:
+		for ; k < len(s); k++ {
+			switch s[k] {
+			case '\\':
+				k++
+			case '(':
+				if depth++; depth > maxParens {
+					return 0, "", "", false
+				}
+			case ')':
+				if depth == 0 {
+					break loop
+				}
+				depth--
+			case ' ', '\n':
+				break loop
+			}
+		}
+		if k > len(s) {
+			k = len(s)
+		}
+		dest = s[j:k]
+		j = k
+	}
+
+	for j < len(s) && (s[j] == ' ' || s[j] == '\n') {
+		j++
+	}
+
+	var title string
+
+	if j < len(s) && (s[j] == '"' || s[j] == '\'' || s[j] == '(') {
+		closing := s[j]
+		if closing == '(' {
+			closing = ')'
+		}
+
+		k := indexByte(s[j+1:], closing, failed)
+		if k == -1 {
+			return 0, "", "", false
+		}
+		title = s[j+1 : j+1+k]
+		j += k + 2
+
+		for j < len(s) && s[j] == ' ' {
+			j++
+		}
+	}
+
+	if j >= len(s) || s[j] != ')' {
+		return 0, "", "", false
+	}
+
+	return j + 1, dest, title, true
+}
+
+// Utility: strings.IndexByte within the rest of the text being rendered,
+// remembering bytes which are missing from it so each is only looked for to the
+// end once
+func indexByte(s string, c byte, failed map[string]bool) int {
+	key := "index " + string(c)
+	if failed[key] {
+		return -1
+	}
+
+	i := strings.IndexByte(s, c)
+	if i == -1 {
+		failed[key] = true
+	}
+	return i
+}
+
+// Utility: write a link, rendering its text as Markdown if markdown is set
+func (r *renderer) link(dest, title, text string, markdown bool) {
+	r.b.WriteString(`<a href="`)
+	r.text(dest)
+	r.b.WriteString(`"`)
+
+	if title != "" {
+		r.b.WriteString(` title="`)
+		r.text(title)
+		r.b.WriteString(`"`)
+	}
+
+	r.b.WriteString(">")
+
+	if markdown {
+		r.inline(text, true)
+	} else {
+		r.text(text)
+	}
+
+	r.b.WriteString("</a>")
+}
+
+// Utility: render emphasis at s[i:], returning its length or zero if the
+// delimiters aren't matched. Runs of one are emphasis, runs of two strong,
+// and runs of two tildes strikethrough.
+func (r *renderer) emphasis(s string, i int, flush func(int), inLink bool,
+	failed map[string]bool) int {
+	c := s[i]
+
+	run := 1
+	for i+run < len(s) && s[i+run] == c {
+		run++
+	}
+
+	// openers are followed by text, and underscores don't emphasise
+	// within words
+	if i+run >= len(s) || isSpace(s[i+run]) ||
+		c == '_' && i > 0 && isWord(s[i-1]) {
+		return 0
+	}
+
+	n := run
+	if n > 2 {
+		n = 2
+	}
+
+	delim := s[i : i+n]
+	if c == '~' && n != 2 || failed[delim] {
+		return 0
+	}
+
+	// the closer is a run of the same length, or the end of a longer run
+	// which also closes nested emphasis
+	for j := i + run; j < len(s); {
+		if s[j] == '\\' {
+			j += 2
+			continue
+		}
+
+		if s[j] != c {
+			j++
+			continue
+		}
+
+		k := j
+		for k < len(s) && s[k] == c {
+			k++
+		}
+
+		closes := !isSpace(s[j-1]) &&
+			(c != '_' || k == len(s) || !isWord(s[k]))
+
+		if closes && (k-j == n || k-j >= 3) {
+			inner := s[i+n : k-n]
+
+			tag := "em"
+			if c == '~' {
+				tag = "del"
+			} else if n == 2 {
+				tag = "strong"
+			}
+
+			flush(i)
+			r.b.WriteString("<" + tag + ">")
+			r.inline(inner, inLink)
+			r.b.WriteString("</" + tag + ">")
+			return k - i
+		}
+
+		j = k
+	}
+
+	failed[delim] = true
+	return 0
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isWord(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9'
+}
+
+func isPunct(c byte) bool {
+	return c > ' ' && c < 0x7f && !isWord(c)
+}
@@ -0,0 +1,500 @@
+package markdown
+
+import (
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxSize is the largest source which is rendered, as links and emphasis
+// without closers are looked for some way ahead.
+const MaxSize = 256 << 10
+
+// Render converts Markdown to HTML. Raw HTML in the source is escaped rather
+// than passed through, and links and images may only use safe schemes, so the
+// result is safe to include in a page. Images are shown as links, as pages
+// may not load them.
+//
+// If rewrite is not nil, it maps the destinations of relative links and
+// images, other than links to fragments of the page itself. Sources larger
+// than MaxSize are shown as preformatted text.
+func Render(src []byte, rewrite func(dest string) string) template.HTML {
+	if len(src) > MaxSize {
+		return template.HTML("<pre>" +
+			template.HTMLEscapeString(string(src)) + "</pre>\n")
+	}
+
+	r := &renderer{
+		refs:    make(map[string]reference),
+		ids:     make(map[string]int),
+		rewrite: rewrite,
+	}
+
+	text := strings.Replace(string(src), "\r\n", "\n", -1)
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+
+	r.blocks(r.definitions(lines), false)
+	return template.HTML(r.b.String())
+}
+
+// reference is the destination of a reference link, from a definition such
+// as `[label]: https://example.com "title"`.
+type reference struct {
+	dest  string
+	title string
+}
+
+type renderer struct {
+	b    strings.Builder
+	refs map[string]reference
+
+	// the ids of headings, counting repeats
+	ids map[string]int
+
+	// how deeply blocks and spans are nested
+	depth int
+
+	rewrite func(string) string
+}
+
+// maxDepth bounds the nesting of blocks and spans, beyond which they are
+// left as text.
+const maxDepth = 32
+
+var (
+	reFence      = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})\\s*([^`\\s]*)")
+	reHeading    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	reBreak      = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	reQuote      = regexp.MustCompile(`^ {0,3}> ?`)
+	reItem       = regexp.MustCompile(`^( {0,3})([-*+]|(\d{1,9})[.)])( +|$)`)
+	reSetext1    = regexp.MustCompile(`^ {0,3}=+[ \t]*$`)
+	reSetext2    = regexp.MustCompile(`^ {0,3}-+[ \t]*$`)
+	reTableSep   = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	reDefinition = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ \t]*<?([^\s>]+)>?(?:[ \t]+(?:"([^"]*)"|'([^']*)'|\(([^)]*)\)))?[ \t]*$`)
+)
+
+// Utility: expand tabs to spaces at four column tab stops
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+
+	var b strings.Builder
+	col := 0
+
+	for _, c := range line {
+		if c == '\t' {
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(c)
+		col++
+	}
+
+	return b.String()
+}
+
+// Utility: collect the link reference definitions, which aren't rendered,
+// returning the other lines
+func (r *renderer) definitions(lines []string) []string {
+	var ret []string
+	var fence string
+
+	for _, line := range lines {
+		// definitions aren't recognized in code blocks
+		if m := reFence.FindStringSubmatch(line); m != nil {
+			if fence == "" {
+				fence = m[2]
+			} else if strings.HasPrefix(m[2], fence) && m[3] == "" {
+				fence = ""
+			}
+		}
+
+		if fence != "" {
+			ret = append(ret, line)
+			continue
+		}
+
+		m := reDefinition.FindStringSubmatch(line)
+		if m == nil {
+			ret = append(ret, line)
+			continue
+		}
+
+		label := normalizeLabel(m[1])
+		if _, ok := r.refs[label]; !ok {
+			r.refs[label] = reference{
+				dest:  m[2],
+				title: m[3] + m[4] + m[5],
+			}
+		}
+	}
+
+	return ret
+}
+
+// Utility: labels match case-insensitively, ignoring repeated spaces
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// Utility: the number of leading spaces
+func indent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// Utility: report whether a line begins a block other than a paragraph,
+// which ends a paragraph before it
+func interrupts(line string) bool {
+	return reFence.MatchString(line) || reHeading.MatchString(line) ||
+		reBreak.MatchString(line) || reQuote.MatchString(line) ||
+		reItem.MatchString(line) && !isBlank(reItem.ReplaceAllString(line, ""))
+}
+
+// Utility: render lines as blocks, without paragraph tags if tight
+func (r *renderer) blocks(lines []string, tight bool) {
+	if r.depth++; r.depth > maxDepth {
+		r.code(lines)
+		r.depth--
+		return
+	}
+	defer func() { r.depth-- }()
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case isBlank(line):
+			i++
+		case reFence.MatchString(line):
+			i = r.fenced(lines, i)
+		case indent(line) >= 4:
+			i = r.indented(lines, i)
+		case reHeading.MatchString(line):
+			m := reHeading.FindStringSubmatch(line)
+			r.heading(len(m[1]), m[2])
+			i++
+		case reBreak.MatchString(line):
+			r.b.WriteString("<hr>\n")
+			i++
+		case reQuote.MatchString(line):
+			i = r.quote(lines, i)
+		case reItem.MatchString(line):
+			i = r.list(lines, i)
+		case i+1 < len(lines) && strings.Contains(line, "|") &&
+			reTableSep.MatchString(lines[i+1]):
+			i = r.table(lines, i)
+		default:
+			i = r.paragraph(lines, i, tight)
+		}
+	}
+}
+
+// Utility: render a heading, with an id to link to from its text
+func (r *renderer) heading(level int, text string) {
+	id := slug(text)
+	if n := r.ids[id]; n > 0 {
+		r.ids[id]++
+		id += "-" + strconv.Itoa(n)
+	} else {
+		r.ids[id] = 1
+	}
+
+	tag := "h" + strconv.Itoa(level)
+	r.b.WriteString("<" + tag + ` id="` + template.HTMLEscapeString(id) +
+		`">`)
+	r.inline(strings.TrimSpace(text), false)
+	r.b.WriteString("</" + tag + ">\n")
+}
+
+// Utility: the id of a heading, as lower case words joined by hyphens
+func slug(text string) string {
+	var b strings.Builder
+
+	for _, c := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case c == ' ':
+			b.WriteByte('-')
+		case c == '-' || c == '_' || c >= 'a' && c <= 'z' ||
+			c >= '0' && c <= '9' || c > 127:
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+// Utility: render a fenced code block, returning the index after it
+func (r *renderer) fenced(lines []string, i int) int {
+	m := reFence.FindStringSubmatch(lines[i])
+	fence, pad := m[2], len(m[1])
+
+	var code []string
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+
+		if indent(line) < 4 && strings.HasPrefix(trimmed, fence) &&
+			strings.Trim(trimmed, fence[:1]+" ") == "" {
+			i++
+			break
+		}
+
+		// the opening fence's indentation is removed from each line
+		n := indent(line)
+		if n > pad {
+			n = pad
+		}
+		code = append(code, line[n:])
+	}
+
+	r.code(code)
+	return i
+}
+
+// Utility: render an indented code block, returning the index after it
+func (r *renderer) indented(lines []string, i int) int {
+	var code []string
+
+	for ; i < len(lines); i++ {
+		if isBlank(lines[i]) {
+			code = append(code, "")
+		} else if indent(lines[i]) >= 4 {
+			code = append(code, lines[i][4:])
+		} else {
+			break
+		}
+	}
+
+	for len(code) > 0 && code[len(code)-1] == "" {
+		code = code[:len(code)-1]
+	}
+
+	r.code(code)
+	return i
+}
+
+func (r *renderer) code(lines []string) {
+	r.b.WriteString("<pre><code>")
+	for _, line := range lines {
+		r.b.WriteString(template.HTMLEscapeString(line) + "\n")
+	}
+	r.b.WriteString("</code></pre>\n")
+}
+
+// Utility: render a block quote, returning the index after it. Lines without
+// the marker continue a paragraph in the quote.
+func (r *renderer) quote(lines []string, i int) int {
+	var inner []string
+
+	for ; i < len(lines); i++ {
+		line := lines[i]
+
+		if loc := reQuote.FindStringIndex(line); loc != nil {
+			inner = append(inner, line[loc[1]:])
+		} else if !isBlank(line) && len(inner) > 0 &&
+			!isBlank(inner[len(inner)-1]) && !interrupts(line) {
+			inner = append(inner, line)
+		} else {
+			break
+		}
+	}
+
+	r.b.WriteString("<blockquote>\n")
+	r.blocks(inner, false)
+	r.b.WriteString("</blockquote>\n")
+	return i
+}
+
+// Utility: render a list, returning the index after it. Items continue on
+// lines indented to their content, and the list is loose, with paragraphs,
+// if any item is separated by a blank line.
+func (r *renderer) list(lines []string, i int) int {
+	first := reItem.FindStringSubmatch(lines[i])
+	ordered := first[3] != ""
+	marker := first[2][len(first[2])-1:]
+
+	var items [][]string
+	loose := false
+	blank := false
+
+	for i < len(lines) {
+		line := lines[i]
+		m := reItem.FindStringSubmatch(line)
+
+		if m == nil || (m[3] != "") != ordered ||
+			m[2][len(m[2])-1:] != marker || reBreak.MatchString(line) {
+			break
+		}
+
+		if blank {
+			loose = true
+		}
+
+		// content begins after the marker and one space, or more up to
+		// four, and the rest of the item is indented to it
+		width := len(m[1]) + len(m[2]) + len(m[4])
+		if len(m[4]) > 4 || len(m[4]) == 0 {
+			width = len(m[1]) + len(m[2]) + 1
+		}
+
+		item := []string{strings.TrimLeft(line[len(m[0]):], " ")}
+		if len(m[4]) > 4 {
+			item[0] = line[width:]
+		}
+
+		blank = false
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+
+			switch {
+			case isBlank(line):
+				item = append(item, "")
+				blank = true
+				continue
+			case indent(line) >= width:
+				if blank {
+					// a blank line inside an item makes the list loose
+					loose = true
+				}
+				item = append(item, line[width:])
+				blank = false
+				continue
+			case !blank && !interrupts(line):
+				// lazy continuation of a paragraph
+				item = append(item, line)
+				continue
+			}
+			break
+		}
+
+		// trailing blank lines separate items rather than paragraphs
+		for len(item) > 0 && item[len(item)-1] == "" {
+			item = item[:len(item)-1]
+		}
+
+		items = append(items, item)
+	}
+
+	if ordered {
+		if n, _ := strconv.Atoi(first[3]); n != 1 {
+			r.b.WriteString(`<ol start="` + strconv.Itoa(n) + "\">\n")
+		} else {
+			r.b.WriteString("<ol>\n")
+		}
+	} else {
+		r.b.WriteString("<ul>\n")
+	}
+
+	for _, item := range items {
+		r.b.WriteString("<li>")
+		r.blocks(item, !loose)
+		r.b.WriteString("</li>\n")
+	}
+
+	if ordered {
+		r.b.WriteString("</ol>\n")
+	} else {
+		r.b.WriteString("</ul>\n")
+	}
+
+	return i
+}
+
+// Utility: render a table with a header row, returning the index after it
+func (r *renderer) table(lines []string, i int) int {
+	r.b.WriteString("<table>\n<thead>\n")
+	r.row(lines[i], "th")
+	r.b.WriteString("</thead>\n<tbody>\n")
+
+	for i += 2; i < len(lines); i++ {
+		if isBlank(lines[i]) || !strings.Contains(lines[i], "|") {
+			break
+		}
+		r.row(lines[i], "td")
+	}
+
+	r.b.WriteString("</tbody>\n</table>\n")
+	return i
+}
+
+// Utility: render a table row, splitting cells on pipes which aren't escaped
+func (r *renderer) row(line, tag string) {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	start := 0
+
+	for j := 0; j < len(line); j++ {
+		switch line[j] {
+		case '\\':
+			j++
+		case '|':
+			cells = append(cells, line[start:j])
+			start = j + 1
+		}
+	}
+	cells = append(cells, line[start:])
+
+	r.b.WriteString("<tr>")
+	for _, cell := range cells {
+		r.b.WriteString("<" + tag + ">")
+		r.inline(strings.TrimSpace(cell), false)
+		r.b.WriteString("</" + tag + ">")
+	}
+	r.b.WriteString("</tr>\n")
+}
+
+// Utility: render a paragraph or a setext heading, returning the index after
+// it
+func (r *renderer) paragraph(lines []string, i int, tight bool) int {
+	var para []string
+
+	for ; i < len(lines); i++ {
+		line := lines[i]
+
+		if len(para) > 0 && reSetext1.MatchString(line) {
+			r.heading(1, strings.Join(para, " "))
+			return i + 1
+		}
+
+		if len(para) > 0 && reSetext2.MatchString(line) {
+			r.heading(2, strings.Join(para, " "))
+			return i + 1
+		}
+
+		if isBlank(line) || len(para) > 0 && interrupts(line) {
+			break
+		}
+
+		para = append(para, strings.TrimLeft(line, " "))
+	}
+
+	if !tight {
+		r.b.WriteString("<p>")
+	}
+
+	r.inline(strings.TrimRight(strings.Join(para, "\n"), " "), false)
+
+	if !tight {
+		r.b.WriteString("</p>")
+	}
+	r.b.WriteString("\n")
+
+	return i
+}
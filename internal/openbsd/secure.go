@@ -3,6 +3,6 @@
 package openbsd
 
 // Secure is a no-op for non-OpenBSD systems.
-func Secure(unveils [][2]string) error {
+func Secure(unveils [][2]string, clone bool) error {
 	return nil
 }
@@ -19,16 +19,24 @@ func init() {
 }
 
 // Secure first unveils the specified paths, then pledges the minimal system
-// calls needed for gitweb.
-func Secure(unveils [][2]string) error {
+// calls needed for gitweb. Programs run by gitweb may only run programs of
+// their own if clone is set.
+func Secure(unveils [][2]string, clone bool) error {
 	if err := unveil(unveils); err != nil {
 		return err
 	}
-	return pledge()
+	return pledge(clone)
 }
 
-func pledge() error {
-	return unix.Pledge("stdio inet rpath proc exec", "stdio rpath")
+func pledge(clone bool) error {
+	execpromises := "stdio rpath"
+
+	// git upload-pack runs git pack-objects to serve clones
+	if clone {
+		execpromises += " proc exec"
+	}
+
+	return unix.Pledge("stdio inet rpath proc exec", execpromises)
 }
 
 func unveil(paths [][2]string) error {
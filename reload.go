@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Reload the configuration file on SIGHUP and rescan the scan path
+// periodically. An invalid configuration is logged and otherwise ignored.
+func watch(file string, conf *config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for {
+		var tick <-chan time.Time
+
+		if conf.ScanPath != "" && conf.scanInterval != 0 {
+			tick = time.After(conf.scanInterval)
+		}
+
+		select {
+		case <-hup:
+			next, err := parseConfig(file)
+			if err == nil {
+				err = initializeRepos(next)
+			}
+
+			if err != nil {
+				log.Println("reload:", err)
+				break
+			}
+
+			next.unveiled = conf.unveiled
+			warnRestart(conf, next)
+			pages.resize(next.CacheSize)
+			conf = next
+			log.Println("reload: done")
+		case <-tick:
+			if err := initializeRepos(conf); err != nil {
+				log.Println("rescan:", err)
+			}
+		}
+	}
+}
+
+// Warn about changed settings which are only applied at startup. Paths are
+// only unveiled at startup, so with OpenBSD restrictions the scan path is too,
+// and repositories outside the unveiled paths can't be read.
+func warnRestart(old, next *config) {
+	restricted := next.unveiled != nil
+
+	if old.Chroot != next.Chroot || old.HTTPS != next.HTTPS ||
+		old.HTTPSCrt != next.HTTPSCrt || old.HTTPSKey != next.HTTPSKey ||
+		old.Port != next.Port || old.OpenBSD != next.OpenBSD ||
+		restricted && old.ScanPath != next.ScanPath {
+		log.Println("reload: server settings changed, restart to apply")
+	}
+
+	if !restricted {
+		return
+	}
+
+	for _, r := range next.Repos {
+		if !isUnveiled(next.unveiled, r.Path) {
+			log.Println("reload:", r.Path,
+				"is not unveiled, restart to apply")
+		}
+	}
+}
+
+// Check if path is one of the unveiled paths or within one of them.
+func isUnveiled(unveiled []string, path string) bool {
+	path = filepath.Clean(path)
+
+	for _, u := range unveiled {
+		u = filepath.Clean(u)
+		if path == u || strings.HasPrefix(path, u+"/") || u == "/" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Log which repositories were added, removed or changed.
+func logChanges(old, next map[string]*repository) {
+	for name, r := range next {
+		if prev, ok := old[name]; !ok {
+			log.Println("added repository", name)
+		} else if prev != r {
+			log.Println("changed repository", name)
+		}
+	}
+
+	for name := range old {
+		if _, ok := next[name]; !ok {
+			log.Println("removed repository", name)
+		}
+	}
+}
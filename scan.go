@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/esote/gitweb/internal/git"
+)
+
+// Walk the directory tree at root for repositories, reading their settings
+// from the repositories themselves. Repositories are not searched for nested
+// repositories.
+func scanRepos(root string) ([]repoConfig, error) {
+	var ret []repoConfig
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo,
+		err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			log.Println(err)
+			return nil
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		bare, ok := isRepository(path)
+		if !ok {
+			return nil
+		}
+
+		c, err := scanConfig(path, bare)
+		if err != nil {
+			log.Println(path, err)
+		} else {
+			ret = append(ret, c)
+		}
+
+		return filepath.SkipDir
+	})
+
+	return ret, err
+}
+
+// Check if path is a repository with a working tree, or a bare repository.
+func isRepository(path string) (bare, ok bool) {
+	if isGitDir(filepath.Join(path, ".git")) {
+		return false, true
+	}
+	if isGitDir(path) {
+		return true, true
+	}
+	return false, false
+}
+
+func isGitDir(path string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(path, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Read the settings of a scanned repository from its description file and the
+// gitweb section of its git config, which uses the config.json keys without
+// underscores (for example gitweb.cacheduration).
+func scanConfig(path string, bare bool) (repoConfig, error) {
+	c := repoConfig{
+		Bare: bare,
+		Path: path,
+		Ref:  "HEAD",
+	}
+
+	gitDir := path
+	if !bare {
+		gitDir = filepath.Join(path, ".git")
+	}
+
+	// git init writes a placeholder description
+	b, err := ioutil.ReadFile(filepath.Join(gitDir, "description"))
+	if err == nil && !bytes.HasPrefix(b, []byte("Unnamed repository;")) {
+		if b = bytes.TrimSpace(b); len(b) != 0 {
+			c.Description = strings.Split(string(b), "\n")
+		}
+	}
+
+	const scanTimeout = 2 * time.Second
+
+	g, err := git.NewGit(path, c.Ref, git.BackendExec, scanTimeout, 0)
+	if err != nil {
+		return c, err
+	}
+
+	vars, err := g.Config("gitweb")
+	if err != nil {
+		return c, err
+	}
+
+	var description []string
+
+	for _, v := range vars {
+		key, value := strings.TrimPrefix(v[0], "gitweb."), v[1]
+
+		switch key {
+		case "archiveformats":
+			c.ArchiveFormats = append(c.ArchiveFormats,
+				strings.Fields(value)...)
+		case "archivemaxsize":
+			c.ArchiveMaxSize, err = strconv.ParseInt(value, 10, 64)
+		case "archivetimeout":
+			c.ArchiveTimeout = value
+		case "backend":
+			c.Backend = value
+		case "cacheduration":
+			c.CacheDuration = value
+		case "clonetimeout":
+			c.CloneTimeout = value
+		case "description":
+			description = append(description, value)
+		case "maxobjectsize":
+			c.MaxObjectSize, err = strconv.ParseInt(value, 10, 64)
+		case "pagesize":
+			c.PageSize, err = strconv.Atoi(value)
+		case "ref":
+			c.Ref = value
+		case "timeout":
+			c.Timeout = value
+		}
+
+		if err != nil {
+			return c, err
+		}
+	}
+
+	if description != nil {
+		c.Description = description
+	}
+
+	return c, nil
+}